@@ -0,0 +1,70 @@
+// Reference plugin serving the file provider over gRPC
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	"github.com/vixus0/skuttle/v2/internal/provider/file"
+	"github.com/vixus0/skuttle/v2/internal/provider/plugin"
+
+	"google.golang.org/grpc"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+)
+
+var (
+	log = logging.NewLogger("file-plugin")
+)
+
+func main() {
+	var argListen string
+
+	listen, ok := os.LookupEnv("LISTEN")
+	if !ok {
+		listen = "unix:///var/run/skuttle/file.sock"
+	}
+
+	flag.StringVar(&argListen, "listen", listen,
+		"address to serve on, either unix:///path/to/socket or host:port",
+	)
+
+	flag.Parse()
+
+	ctx := signals.SetupSignalHandler()
+
+	p, err := file.NewProvider(ctx)
+	if err != nil {
+		log.Fatalf("error creating file provider: %v", err)
+	}
+
+	network, address := "tcp", argListen
+	if strings.HasPrefix(argListen, "unix://") {
+		network, address = "unix", strings.TrimPrefix(argListen, "unix://")
+
+		// Clean up after a previous run
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatalf("could not listen on %s: %v", argListen, err)
+	}
+
+	server := grpc.NewServer()
+	plugin.NewServer(p).Register(server)
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	log.Info("serving on %s", argListen)
+	if err := server.Serve(listener); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -12,14 +14,33 @@ import (
 	"github.com/vixus0/skuttle/v2/internal/logging"
 	"github.com/vixus0/skuttle/v2/internal/provider"
 	"github.com/vixus0/skuttle/v2/internal/provider/aws"
+	"github.com/vixus0/skuttle/v2/internal/provider/azure"
+	"github.com/vixus0/skuttle/v2/internal/provider/capi"
+	"github.com/vixus0/skuttle/v2/internal/provider/digitalocean"
+	"github.com/vixus0/skuttle/v2/internal/provider/exec"
 	"github.com/vixus0/skuttle/v2/internal/provider/file"
-
+	"github.com/vixus0/skuttle/v2/internal/provider/gce"
+	"github.com/vixus0/skuttle/v2/internal/provider/hcloud"
+	"github.com/vixus0/skuttle/v2/internal/provider/karpenter"
+	"github.com/vixus0/skuttle/v2/internal/provider/kubevirt"
+	"github.com/vixus0/skuttle/v2/internal/provider/libvirt"
+	"github.com/vixus0/skuttle/v2/internal/provider/openstack"
+	"github.com/vixus0/skuttle/v2/internal/provider/plugin"
+	"github.com/vixus0/skuttle/v2/internal/provider/vsphere"
+	"github.com/vixus0/skuttle/v2/internal/provider/webhook"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 )
 
@@ -30,27 +51,228 @@ var (
 func main() {
 	// Startup flags
 	var (
-		argDryRun           bool
-		argLogLevel         string
-		argKubeconfig       string
-		argNodeSelector     string
-		argNotReadyDuration time.Duration
-		argRefreshDuration  time.Duration
-		argProviders        string
+		argAWSAssumeRoles      string
+		argAWSAccessKeyID      string
+		argAWSEndpoint         string
+		argAWSSecretAccessKey  string
+		argAWSGoneStates       string
+		argAzureGoneStates     string
+		argAWSSkipDryRun       bool
+		argBatchWindow         time.Duration
+		argCAPINamespace       string
+		argCAPIDeleteMachines  bool
+		argCacheTTL            time.Duration
+		argCacheNegativeTTL    time.Duration
+		argCacheErrorTTL       time.Duration
+		argCircuitFailures     int
+		argCleanVolumes        bool
+		argCleanVolumesForce   bool
+		argCircuitOpenDuration time.Duration
+		argDrain               bool
+		argDrainSkipDaemonSets bool
+		argDrainTimeout        time.Duration
+		argDryRun              bool
+		argDOGoneStates        string
+		argHcloudGoneStates    string
+		argKubevirtKubeconfig  string
+		argKubevirtNamespace   string
+		argLeaderElect         bool
+		argLeaseDuration       time.Duration
+		argLeaseName           string
+		argLeaseNamespace      string
+		argLeaseRenewDeadline  time.Duration
+		argLeaseRetryPeriod    time.Duration
+		argLibvirtURIs         string
+		argLibvirtTimeout      time.Duration
+		argLogLevel            string
+		argMaxDeletionsCM      string
+		argMaxDeletionsPerHour int
+		argMaxNotReady         int
+		argMaxNotReadyPercent  float64
+		argKubeconfig          string
+		argMetricsAddr         string
+		argNodeSelector        string
+		argNotReadyDuration    time.Duration
+		argRefreshDuration     time.Duration
+		argRetryBaseDelay      time.Duration
+		argRetryMaxDelay       time.Duration
+		argProviders           string
+		argProviderQPS         float64
+		argProviderBurst       int
+		argProviderRateLimits  string
+		argExecPlugins         string
+		argExecTimeout         time.Duration
+		argPluginAddresses     string
+		argPluginTimeout       time.Duration
+		argWebhookURLs         string
+		argWebhookTimeout      time.Duration
+		argWebhookTokenFile    string
+		argWebhookCAFile       string
+		argWebhookCertFile     string
+		argWebhookKeyFile      string
+		argWorkers             int
+	)
+
+	flag.StringVar(&argAWSAssumeRoles, "aws-assume-roles", StringEnv("AWS_ASSUME_ROLES", ""),
+		"comma-separated list of IAM role ARNs to assume to find EC2 instances in other accounts",
+	)
+
+	flag.StringVar(&argAWSAccessKeyID, "aws-access-key-id", "",
+		"static AWS access key ID to use instead of the default credential chain",
+	)
+
+	flag.StringVar(&argAWSEndpoint, "aws-endpoint", StringEnv("AWS_ENDPOINT", ""),
+		"URL of an EC2 compatible API to use instead of AWS, such as LocalStack",
+	)
+
+	flag.StringVar(&argAWSGoneStates, "aws-gone-states", StringEnv("AWS_GONE_STATES", strings.Join(aws.DefaultGoneStates, ",")),
+		"comma-separated list of EC2 instance states which count as gone, including missing",
+	)
+
+	flag.StringVar(&argAWSSecretAccessKey, "aws-secret-access-key", "",
+		"static AWS secret access key to use with -aws-access-key-id",
+	)
+
+	flag.BoolVar(&argAWSSkipDryRun, "aws-skip-dry-run", BoolEnv("AWS_SKIP_DRY_RUN", false),
+		"don't check EC2 permissions with a dry run on startup",
+	)
+
+	flag.StringVar(&argAzureGoneStates, "azure-gone-states", StringEnv("AZURE_GONE_STATES", strings.Join(azure.DefaultGoneStates, ",")),
+		"comma-separated list of VM instance view statuses which count as gone, including missing",
+	)
+
+	flag.DurationVar(&argBatchWindow, "batch-window", DurationEnv("BATCH_WINDOW", "2s"),
+		"time to collect NotReady nodes for before checking them together, 0 to check each node straight away",
+	)
+
+	flag.StringVar(&argCAPINamespace, "capi-namespace", StringEnv("CAPI_NAMESPACE", ""),
+		"namespace to look for Cluster API Machines in, all namespaces if empty",
+	)
+
+	flag.BoolVar(&argCAPIDeleteMachines, "capi-delete-machines", BoolEnv("CAPI_DELETE_MACHINES", false),
+		"delete the Cluster API Machine as well as the node when its instance is gone",
+	)
+
+	flag.DurationVar(&argCacheTTL, "cache-ttl", DurationEnv("CACHE_TTL", "30s"),
+		"how long to remember that an instance exists, 0 to always ask the provider",
+	)
+
+	flag.DurationVar(&argCacheNegativeTTL, "cache-negative-ttl", DurationEnv("CACHE_NEGATIVE_TTL", "10s"),
+		"how long to remember that an instance doesn't exist, 0 to always ask the provider",
+	)
+
+	flag.DurationVar(&argCacheErrorTTL, "cache-error-ttl", DurationEnv("CACHE_ERROR_TTL", "5s"),
+		"how long to remember a provider error before asking again, 0 to always ask the provider",
+	)
+
+	flag.IntVar(&argCircuitFailures, "circuit-failures", IntEnv("CIRCUIT_FAILURES", 5),
+		"consecutive provider errors after which skuttle stops calling the provider for a while, 0 to keep calling",
+	)
+
+	flag.BoolVar(&argCleanVolumes, "clean-volume-attachments", BoolEnv("CLEAN_VOLUME_ATTACHMENTS", false),
+		"delete the VolumeAttachments of deleted nodes, so their volumes can be attached elsewhere",
+	)
+
+	flag.BoolVar(&argCleanVolumesForce, "clean-volume-attachments-finalizers", BoolEnv("CLEAN_VOLUME_ATTACHMENTS_FINALIZERS", false),
+		"also remove VolumeAttachments' finalizers once the provider confirms the volume is detached",
+	)
+
+	flag.DurationVar(&argCircuitOpenDuration, "circuit-open-duration", DurationEnv("CIRCUIT_OPEN_DURATION", "1m"),
+		"how long to stop calling a failing provider before trying it again",
+	)
+
+	flag.BoolVar(&argDrain, "drain", BoolEnv("DRAIN", false),
+		"cordon nodes and evict their pods before deleting them",
+	)
+
+	flag.BoolVar(&argDrainSkipDaemonSets, "drain-skip-daemonsets", BoolEnv("DRAIN_SKIP_DAEMONSETS", true),
+		"leave pods owned by DaemonSets when draining nodes",
+	)
+
+	flag.DurationVar(&argDrainTimeout, "drain-timeout", DurationEnv("DRAIN_TIMEOUT", "1m"),
+		"time to keep evicting pods which PodDisruptionBudgets protect before force deleting them",
 	)
 
 	flag.BoolVar(&argDryRun, "dry-run", BoolEnv("DRY_RUN", false),
 		"dry run mode to only log instead of scheduling deletion",
 	)
 
+	flag.StringVar(&argDOGoneStates, "digitalocean-gone-states", StringEnv("DIGITALOCEAN_GONE_STATES", strings.Join(digitalocean.DefaultGoneStates, ",")),
+		"comma-separated list of droplet statuses which count as gone, including missing",
+	)
+
+	flag.StringVar(&argHcloudGoneStates, "hcloud-gone-states", StringEnv("HCLOUD_GONE_STATES", strings.Join(hcloud.DefaultGoneStates, ",")),
+		"comma-separated list of Hetzner Cloud server statuses which count as gone, including missing",
+	)
+
+	flag.StringVar(&argKubevirtKubeconfig, "kubevirt-kubeconfig", StringEnv("KUBEVIRT_KUBECONFIG", ""),
+		"path to a kubeconfig for the cluster running KubeVirt VMs, in-cluster config if empty",
+	)
+
+	flag.StringVar(&argKubevirtNamespace, "kubevirt-namespace", StringEnv("KUBEVIRT_NAMESPACE", "default"),
+		"namespace of KubeVirt VMs, unless a node's provider ID includes one",
+	)
+
+	flag.BoolVar(&argLeaderElect, "leader-elect", BoolEnv("LEADER_ELECT", false),
+		"only reconcile nodes while holding a Lease, so several replicas can run at once",
+	)
+
+	flag.DurationVar(&argLeaseDuration, "leader-elect-lease-duration", DurationEnv("LEADER_ELECT_LEASE_DURATION", "15s"),
+		"time other replicas wait before taking over the Lease from a leader which stopped renewing it",
+	)
+
+	flag.StringVar(&argLeaseName, "leader-elect-lease-name", StringEnv("LEADER_ELECT_LEASE_NAME", "skuttle"),
+		"name of the Lease used for leader election",
+	)
+
+	flag.StringVar(&argLeaseNamespace, "leader-elect-namespace", StringEnv("LEADER_ELECT_NAMESPACE", "kube-system"),
+		"namespace of the Lease used for leader election",
+	)
+
+	flag.DurationVar(&argLeaseRenewDeadline, "leader-elect-renew-deadline", DurationEnv("LEADER_ELECT_RENEW_DEADLINE", "10s"),
+		"time the leader keeps trying to renew the Lease before giving up leadership",
+	)
+
+	flag.DurationVar(&argLeaseRetryPeriod, "leader-elect-retry-period", DurationEnv("LEADER_ELECT_RETRY_PERIOD", "2s"),
+		"time to wait between attempts to acquire or renew the Lease",
+	)
+
+	flag.StringVar(&argLibvirtURIs, "libvirt-uris", StringEnv("LIBVIRT_URIS", "qemu:///system"),
+		"comma-separated list of libvirt URIs of hosts to look for domains on",
+	)
+
+	flag.DurationVar(&argLibvirtTimeout, "libvirt-timeout", DurationEnv("LIBVIRT_TIMEOUT", "10s"),
+		"time to wait when connecting to a libvirt host",
+	)
+
 	flag.StringVar(&argLogLevel, "log-level", StringEnv("LOG_LEVEL", "info"),
 		"log level (debug, info, warn, error)",
 	)
 
+	flag.StringVar(&argMaxDeletionsCM, "max-deletions-configmap", StringEnv("MAX_DELETIONS_CONFIGMAP", "kube-system/skuttle-deletions"),
+		"namespace/name of a ConfigMap to record deletions in for -max-deletions-per-hour, empty to only keep them in memory",
+	)
+
+	flag.IntVar(&argMaxDeletionsPerHour, "max-deletions-per-hour", IntEnv("MAX_DELETIONS_PER_HOUR", 0),
+		"most nodes to delete in any hour, 0 for no limit",
+	)
+
+	flag.IntVar(&argMaxNotReady, "max-not-ready", IntEnv("MAX_NOT_READY", 0),
+		"don't delete nodes while more than this many selected nodes are NotReady, 0 for no limit",
+	)
+
+	flag.Float64Var(&argMaxNotReadyPercent, "max-not-ready-percent", FloatEnv("MAX_NOT_READY_PERCENT", 0),
+		"don't delete nodes while more than this percentage of selected nodes are NotReady, 0 for no limit",
+	)
+
 	flag.StringVar(&argKubeconfig, "kubeconfig", StringEnv("KUBECONFIG", ""),
 		"path to kubeconfig file if not running in-cluster",
 	)
 
+	flag.StringVar(&argMetricsAddr, "metrics-addr", StringEnv("METRICS_ADDR", ":8080"),
+		"address to serve Prometheus metrics on, empty to turn off",
+	)
+
 	flag.StringVar(&argNodeSelector, "node-selector", StringEnv("NODE_SELECTOR", "node.kubernetes.io/node"),
 		"selector used to filter nodes skuttle should manage",
 	)
@@ -63,8 +285,72 @@ func main() {
 		"refresh duration",
 	)
 
+	flag.DurationVar(&argRetryBaseDelay, "retry-base-delay", DurationEnv("RETRY_BASE_DELAY", "1s"),
+		"time to wait before checking a node again after an error, doubling with each consecutive error",
+	)
+
+	flag.DurationVar(&argRetryMaxDelay, "retry-max-delay", DurationEnv("RETRY_MAX_DELAY", "5m"),
+		"longest time to wait before checking a node again after errors",
+	)
+
 	flag.StringVar(&argProviders, "providers", StringEnv("PROVIDERS", ""),
-		"comma-separated list of enabled providers",
+		"comma-separated list of enabled providers, or prefix=provider pairs to use a provider for other prefixes",
+	)
+
+	flag.Float64Var(&argProviderQPS, "provider-qps", FloatEnv("PROVIDER_QPS", 5),
+		"calls per second allowed to each provider, 0 for no limit",
+	)
+
+	flag.IntVar(&argProviderBurst, "provider-burst", IntEnv("PROVIDER_BURST", 10),
+		"calls allowed at once to each provider before -provider-qps applies",
+	)
+
+	flag.StringVar(&argProviderRateLimits, "provider-rate-limits", StringEnv("PROVIDER_RATE_LIMITS", ""),
+		"comma-separated list of prefix=qps:burst pairs overriding -provider-qps and -provider-burst",
+	)
+
+	flag.StringVar(&argExecPlugins, "exec-plugins", StringEnv("EXEC_PLUGINS", ""),
+		"comma-separated list of prefix=path pairs of plugin binaries to run for other providers",
+	)
+
+	flag.DurationVar(&argExecTimeout, "exec-timeout", DurationEnv("EXEC_TIMEOUT", "30s"),
+		"time to wait for a plugin binary to return a verdict",
+	)
+
+	flag.StringVar(&argPluginAddresses, "plugin-addresses", StringEnv("PLUGIN_ADDRESSES", ""),
+		"comma-separated list of prefix=address pairs of gRPC plugins to ask for other providers",
+	)
+
+	flag.DurationVar(&argPluginTimeout, "plugin-timeout", DurationEnv("PLUGIN_TIMEOUT", "30s"),
+		"time to wait for a gRPC plugin to respond",
+	)
+
+	flag.StringVar(&argWebhookURLs, "webhook-urls", StringEnv("WEBHOOK_URLS", ""),
+		"comma-separated list of prefix=url pairs of webhooks to ask for other providers",
+	)
+
+	flag.DurationVar(&argWebhookTimeout, "webhook-timeout", DurationEnv("WEBHOOK_TIMEOUT", "30s"),
+		"time to wait for a webhook to respond",
+	)
+
+	flag.StringVar(&argWebhookTokenFile, "webhook-token-file", StringEnv("WEBHOOK_TOKEN_FILE", ""),
+		"path to a bearer token to send to webhooks",
+	)
+
+	flag.StringVar(&argWebhookCAFile, "webhook-ca-file", StringEnv("WEBHOOK_CA_FILE", ""),
+		"path to a CA bundle to verify webhooks with",
+	)
+
+	flag.StringVar(&argWebhookCertFile, "webhook-cert-file", StringEnv("WEBHOOK_CERT_FILE", ""),
+		"path to a client certificate to present to webhooks",
+	)
+
+	flag.StringVar(&argWebhookKeyFile, "webhook-key-file", StringEnv("WEBHOOK_KEY_FILE", ""),
+		"path to the client certificate key",
+	)
+
+	flag.IntVar(&argWorkers, "workers", IntEnv("WORKERS", 2),
+		"number of nodes to check at once",
 	)
 
 	flag.Parse()
@@ -110,33 +396,228 @@ func main() {
 
 	// Populate store of cloud instance providers
 	cleanProviders := strings.TrimSpace(argProviders)
-	if cleanProviders == "" {
+	cleanExecPlugins := strings.TrimSpace(argExecPlugins)
+	cleanPluginAddresses := strings.TrimSpace(argPluginAddresses)
+	cleanWebhookURLs := strings.TrimSpace(argWebhookURLs)
+	if cleanProviders == "" && cleanExecPlugins == "" && cleanPluginAddresses == "" && cleanWebhookURLs == "" {
 		log.Fatal("No providers specified!")
 	}
 
 	providerStore := &provider.DefaultStore{}
-	providerPrefixes := strings.Split(cleanProviders, ",")
 
-	for _, prefix := range providerPrefixes {
-		var (
-			err error
-			p   provider.Provider
-		)
+	if cleanProviders != "" {
+		for _, entry := range strings.Split(cleanProviders, ",") {
+			var (
+				err error
+				p   provider.Provider
+			)
+
+			// Either a provider name, handling its own prefix, or prefix=name
+			prefix, name := entry, entry
+			if parts := strings.SplitN(entry, "=", 2); len(parts) == 2 {
+				prefix, name = parts[0], parts[1]
+			}
+
+			if _, err := providerStore.Get(prefix); err == nil {
+				log.Fatalf("%s is handled by more than one provider", prefix)
+			}
+
+			switch name {
+			case "aws":
+				var roleARNs []string
+				if argAWSAssumeRoles != "" {
+					roleARNs = strings.Split(argAWSAssumeRoles, ",")
+				}
+				p, err = aws.NewProvider(ctx, &aws.Config{
+					GoneStates:      strings.Split(argAWSGoneStates, ","),
+					RoleARNs:        roleARNs,
+					Endpoint:        argAWSEndpoint,
+					AccessKeyID:     argAWSAccessKeyID,
+					SecretAccessKey: argAWSSecretAccessKey,
+					SkipDryRun:      argAWSSkipDryRun,
+				})
+			case "azure":
+				p, err = azure.NewProvider(&azure.Config{
+					GoneStates: strings.Split(argAzureGoneStates, ","),
+				})
+			case "capi":
+				if prefix == name {
+					log.Fatalf("capi needs the prefixes of its machines' provider IDs, e.g. aws=capi")
+				}
+				p, err = capi.NewProvider(config, &capi.Config{
+					Namespace:      argCAPINamespace,
+					DeleteMachines: argCAPIDeleteMachines,
+				})
+			case "digitalocean":
+				p, err = digitalocean.NewProvider(&digitalocean.Config{
+					GoneStates: strings.Split(argDOGoneStates, ","),
+				})
+			case "file":
+				p, err = file.NewProvider(ctx)
+			case "hcloud":
+				p, err = hcloud.NewProvider(&hcloud.Config{
+					GoneStates: strings.Split(argHcloudGoneStates, ","),
+				})
+			case "karpenter":
+				if prefix == name {
+					log.Fatalf("karpenter needs the prefixes of its nodeclaims' provider IDs, e.g. aws=karpenter")
+				}
+				p, err = karpenter.NewProvider(config)
+			case "gce":
+				p, err = gce.NewProvider(ctx)
+			case "kubevirt":
+				p, err = kubevirt.NewProvider(&kubevirt.Config{
+					Kubeconfig: argKubevirtKubeconfig,
+					Namespace:  argKubevirtNamespace,
+				})
+			case "libvirt":
+				p, err = libvirt.NewProvider(strings.Split(argLibvirtURIs, ","), argLibvirtTimeout)
+			case "openstack":
+				p, err = openstack.NewProvider()
+			case "vsphere":
+				p, err = vsphere.NewProvider(ctx)
+			default:
+				log.Fatalf("no provider available for %s", name)
+			}
+
+			if err != nil {
+				log.Fatalf("error creating provider %v: %v", name, err)
+			}
+
+			if prefix != name {
+				log.Info("using provider %s for %s", name, prefix)
+			}
+			providerStore.Add(prefix, p)
+		}
+	}
+
+	if cleanExecPlugins != "" {
+		plugins, err := ParseMapping(cleanExecPlugins)
+		if err != nil {
+			log.Fatalf("invalid exec plugins: %v", err)
+		}
+
+		for prefix, path := range plugins {
+			if _, err := providerStore.Get(prefix); err == nil {
+				log.Fatalf("plugin %s would replace the %s provider", path, prefix)
+			}
+
+			p, err := exec.NewProvider(path, argExecTimeout)
+			if err != nil {
+				log.Fatalf("error creating plugin provider %v: %v", prefix, err)
+			}
+
+			log.Info("using plugin %s for %s", path, prefix)
+			providerStore.Add(prefix, p)
+		}
+	}
+
+	if cleanPluginAddresses != "" {
+		plugins, err := ParseMapping(cleanPluginAddresses)
+		if err != nil {
+			log.Fatalf("invalid plugin addresses: %v", err)
+		}
+
+		for prefix, address := range plugins {
+			if _, err := providerStore.Get(prefix); err == nil {
+				log.Fatalf("plugin %s would replace the %s provider", address, prefix)
+			}
+
+			p, err := plugin.NewProvider(address, argPluginTimeout)
+			if err != nil {
+				log.Fatalf("error creating plugin provider %v: %v", prefix, err)
+			}
+			defer p.Close()
 
-		switch prefix {
-		case "aws":
-			p, err = aws.NewProvider(ctx)
-		case "file":
-			p, err = file.NewProvider()
-		default:
-			log.Fatalf("no provider available for %s", prefix)
+			// Sidecars may still be starting, so only warn
+			if err := p.Healthy(); err != nil {
+				log.Warn(err.Error())
+			}
+
+			log.Info("using plugin %s for %s", address, prefix)
+			providerStore.Add(prefix, p)
 		}
+	}
 
+	if cleanWebhookURLs != "" {
+		webhooks, err := ParseMapping(cleanWebhookURLs)
 		if err != nil {
-			log.Fatalf("error creating provider %v: %v", prefix, err)
+			log.Fatalf("invalid webhook URLs: %v", err)
+		}
+
+		for prefix, url := range webhooks {
+			if _, err := providerStore.Get(prefix); err == nil {
+				log.Fatalf("webhook %s would replace the %s provider", url, prefix)
+			}
+
+			p, err := webhook.NewProvider(&webhook.Config{
+				URL:       url,
+				Timeout:   argWebhookTimeout,
+				TokenFile: argWebhookTokenFile,
+				CAFile:    argWebhookCAFile,
+				CertFile:  argWebhookCertFile,
+				KeyFile:   argWebhookKeyFile,
+			})
+			if err != nil {
+				log.Fatalf("error creating webhook provider %v: %v", prefix, err)
+			}
+
+			log.Info("using webhook %s for %s", url, prefix)
+			providerStore.Add(prefix, p)
+		}
+	}
+
+	// Protect providers from too many calls
+	rateLimits := map[string]string{}
+	if cleanRateLimits := strings.TrimSpace(argProviderRateLimits); cleanRateLimits != "" {
+		if rateLimits, err = ParseMapping(cleanRateLimits); err != nil {
+			log.Fatalf("invalid provider rate limits: %v", err)
+		}
+	}
+
+	for prefix, p := range *providerStore {
+		limitConfig := &provider.LimitConfig{
+			QPS:              argProviderQPS,
+			Burst:            argProviderBurst,
+			FailureThreshold: argCircuitFailures,
+			OpenDuration:     argCircuitOpenDuration,
+		}
+
+		if rateLimit, ok := rateLimits[prefix]; ok {
+			if _, err := fmt.Sscanf(rateLimit, "%g:%d", &limitConfig.QPS, &limitConfig.Burst); err != nil {
+				log.Fatalf("invalid rate limit for %s, expected qps:burst, got %q", prefix, rateLimit)
+			}
+			delete(rateLimits, prefix)
+		}
+
+		providerStore.Add(prefix, provider.NewLimitedProvider(p, prefix, limitConfig))
+	}
+
+	for prefix := range rateLimits {
+		log.Fatalf("rate limit for %s, which has no provider", prefix)
+	}
+
+	// Remember provider answers
+	cacheConfig := &provider.CacheConfig{
+		TTL:         argCacheTTL,
+		NegativeTTL: argCacheNegativeTTL,
+		ErrorTTL:    argCacheErrorTTL,
+	}
+	if cacheConfig.TTL > 0 || cacheConfig.NegativeTTL > 0 || cacheConfig.ErrorTTL > 0 {
+		for prefix, p := range *providerStore {
+			providerStore.Add(prefix, provider.NewCachingProvider(p, prefix, cacheConfig))
 		}
+	}
 
-		providerStore.Add(prefix, p)
+	// Serve metrics
+	if argMetricsAddr != "" {
+		http.Handle("/metrics", promhttp.Handler())
+		go func() {
+			log.Info("serving metrics on %s", argMetricsAddr)
+			if err := http.ListenAndServe(argMetricsAddr, nil); err != nil {
+				log.Fatalf("could not serve metrics: %v", err)
+			}
+		}()
 	}
 
 	// Create node informer
@@ -146,26 +627,139 @@ func main() {
 	informerFactory := informers.NewSharedInformerFactoryWithOptions(clientset, argRefreshDuration, tweakListOptions)
 	nodeInformer := informerFactory.Core().V1().Nodes().Informer()
 
+	// Record events on nodes
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: clientset.CoreV1().Events(""),
+	})
+	defer eventBroadcaster.Shutdown()
+	eventRecorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "skuttle"})
+
 	// Create controller
 	cfg := &controller.Config{
 		DryRun:           argDryRun,
 		NotReadyDuration: argNotReadyDuration,
 		Providers:        providerStore,
+		BatchWindow:      argBatchWindow,
+		Workers:          argWorkers,
+		RetryBaseDelay:   argRetryBaseDelay,
+		RetryMaxDelay:    argRetryMaxDelay,
+		Safety: controller.SafetyConfig{
+			MaxNotReady:         argMaxNotReady,
+			MaxNotReadyPercent:  argMaxNotReadyPercent,
+			MaxDeletionsPerHour: argMaxDeletionsPerHour,
+		},
+		Recorder: eventRecorder,
+	}
+	if argMaxDeletionsPerHour > 0 && argMaxDeletionsCM != "" {
+		parts := strings.SplitN(argMaxDeletionsCM, "/", 2)
+		if len(parts) != 2 {
+			log.Fatalf("-max-deletions-configmap should be namespace/name, got %s", argMaxDeletionsCM)
+		}
+		cfg.Safety.DeletionLog = controller.NewConfigMapDeletionLog(clientset, parts[0], parts[1])
+	}
+	if argCleanVolumes {
+		cfg.VolumeCleaner = controller.NewVolumeAttachmentCleaner(clientset, &controller.VolumeAttachmentConfig{
+			RemoveFinalizers: argCleanVolumesForce,
+		})
+	}
+	if argDrain {
+		cfg.Drainer = controller.NewDrainer(clientset, &controller.DrainConfig{
+			Timeout:        argDrainTimeout,
+			SkipDaemonSets: argDrainSkipDaemonSets,
+		})
 	}
 	nodeClient := clientset.CoreV1().Nodes()
-	controller.NewController(cfg, ctx, nodeClient, nodeInformer)
 
-	// Start all informers created by factory
-	informerFactory.Start(ctx.Done())
+	// Reconcile nodes until the context is done
+	run := func(ctx context.Context) {
+		ctrl := controller.NewController(cfg, ctx, nodeClient, nodeInformer)
 
-	// Wait for informer to sync
-	log.Info("wait for sync")
-	if !cache.WaitForCacheSync(ctx.Done(), nodeInformer.HasSynced) {
-		runtime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
+		// Start all informers created by factory
+		informerFactory.Start(ctx.Done())
+
+		log.Info("starting")
+		ctrl.Run()
+	}
+
+	if !argLeaderElect {
+		run(ctx)
+	} else {
+		identity, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("could not get hostname for leader election: %v", err)
+		}
+
+		lock := &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      argLeaseName,
+				Namespace: argLeaseNamespace,
+			},
+			Client: clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: identity,
+			},
+		}
+
+		// The election gets its own context, so the lease is only released
+		// once the controller has stopped rather than as soon as ctx is done
+		electionCtx, stopElection := context.WithCancel(context.Background())
+		elected := make(chan struct{})
+		leading := make(chan context.Context, 1)
+
+		log.Info("waiting to lead as %s with lease %s/%s", identity, argLeaseNamespace, argLeaseName)
+		go func() {
+			defer close(elected)
+			leaderelection.RunOrDie(electionCtx, leaderelection.LeaderElectionConfig{
+				Lock:            lock,
+				LeaseDuration:   argLeaseDuration,
+				RenewDeadline:   argLeaseRenewDeadline,
+				RetryPeriod:     argLeaseRetryPeriod,
+				ReleaseOnCancel: true,
+				Name:            argLeaseName,
+				Callbacks: leaderelection.LeaderCallbacks{
+					OnStartedLeading: func(leaderCtx context.Context) {
+						leading <- leaderCtx
+					},
+					OnStoppedLeading: func() {
+						log.Info("stopped leading")
+					},
+					OnNewLeader: func(leader string) {
+						if leader != identity {
+							log.Info("%s is leading", leader)
+						}
+					},
+				},
+			})
+		}()
+
+		// Run the controller here rather than in OnStartedLeading, which
+		// RunOrDie doesn't wait for, until either ctx is done or the lease
+		// is lost
+		select {
+		case leaderCtx := <-leading:
+			runCtx, stopRun := context.WithCancel(leaderCtx)
+			go func() {
+				select {
+				case <-ctx.Done():
+					stopRun()
+				case <-runCtx.Done():
+				}
+			}()
+			run(runCtx)
+			stopRun()
+		case <-ctx.Done():
+		}
+
+		stopElection()
+		<-elected
+
+		// Informers can't be restarted, so start again as a follower
+		if ctx.Err() == nil {
+			log.Fatal("lost leadership")
+		}
 	}
 
-	log.Info("starting")
-	<-ctx.Done()
 	if err = ctx.Err(); err != nil {
 		runtime.HandleError(err)
 	}
@@ -189,6 +783,28 @@ func BoolEnv(key string, defaultVal bool) bool {
 	return defaultVal
 }
 
+func IntEnv(key string, defaultVal int) int {
+	if val, ok := os.LookupEnv(key); ok {
+		ret, err := strconv.Atoi(val)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return ret
+	}
+	return defaultVal
+}
+
+func FloatEnv(key string, defaultVal float64) float64 {
+	if val, ok := os.LookupEnv(key); ok {
+		ret, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return ret
+	}
+	return defaultVal
+}
+
 func DurationEnv(key string, defaultVal string) time.Duration {
 	val, ok := os.LookupEnv(key)
 	if !ok {
@@ -202,3 +818,18 @@ func DurationEnv(key string, defaultVal string) time.Duration {
 
 	return ret
 }
+
+// ParseMapping parses a comma-separated list of key=value pairs
+func ParseMapping(s string) (map[string]string, error) {
+	mapping := map[string]string{}
+
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		mapping[parts[0]] = parts[1]
+	}
+
+	return mapping, nil
+}
@@ -3,14 +3,35 @@ module github.com/vixus0/skuttle/v2
 go 1.16
 
 require (
+	github.com/Azure/azure-sdk-for-go v55.8.0+incompatible
+	github.com/Azure/go-autorest/autorest v0.11.18
+	github.com/Azure/go-autorest/autorest/adal v0.9.20 // indirect
+	github.com/Azure/go-autorest/autorest/azure/auth v0.5.7
+	github.com/Azure/go-autorest/autorest/to v0.4.1
+	github.com/Azure/go-autorest/autorest/validation v0.3.1 // indirect
 	github.com/aws/aws-sdk-go-v2 v1.6.0
 	github.com/aws/aws-sdk-go-v2/config v1.3.0
+	github.com/aws/aws-sdk-go-v2/credentials v1.2.1
 	github.com/aws/aws-sdk-go-v2/service/ec2 v1.9.0
+	github.com/aws/aws-sdk-go-v2/service/sts v1.4.1
 	github.com/aws/smithy-go v1.4.0
+	github.com/digitalocean/go-libvirt v0.0.0-20210723161134-761cfeeb5968
+	github.com/digitalocean/godo v1.65.0
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/gophercloud/gophercloud v0.20.0
+	github.com/gophercloud/utils v0.0.0-20210909165623-d7085207ff6d
+	github.com/hetznercloud/hcloud-go v1.33.1
 	github.com/onsi/ginkgo v1.16.4
 	github.com/onsi/gomega v1.13.0
+	github.com/prometheus/client_golang v1.11.0
+	github.com/vmware/govmomi v0.26.1
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+	google.golang.org/api v0.47.0
+	google.golang.org/grpc v1.37.1
+	google.golang.org/protobuf v1.26.0
 	k8s.io/api v0.21.1
 	k8s.io/apimachinery v0.21.1
 	k8s.io/client-go v0.21.1
 	sigs.k8s.io/controller-runtime v0.9.0
+	sigs.k8s.io/yaml v1.2.0
 )
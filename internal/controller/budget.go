@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DeletionLog keeps the times nodes were deleted, so the hourly deletion
+// budget isn't reset when skuttle restarts or another replica takes over
+type DeletionLog interface {
+	Load(ctx context.Context) ([]time.Time, error)
+	Save(ctx context.Context, deletions []time.Time) error
+}
+
+// The ConfigMap key deletions are kept under
+const deletionsKey = "deletions"
+
+// ConfigMapDeletionLog keeps deletion times in a ConfigMap, creating it if
+// it doesn't exist
+type ConfigMapDeletionLog struct {
+	Namespace string
+	Name      string
+	client    kubernetes.Interface
+}
+
+func NewConfigMapDeletionLog(client kubernetes.Interface, namespace, name string) *ConfigMapDeletionLog {
+	return &ConfigMapDeletionLog{
+		Namespace: namespace,
+		Name:      name,
+		client:    client,
+	}
+}
+
+func (l *ConfigMapDeletionLog) Load(ctx context.Context) ([]time.Time, error) {
+	cm, err := l.client.CoreV1().ConfigMaps(l.Namespace).Get(ctx, l.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get configmap %s/%s: %v", l.Namespace, l.Name, err)
+	}
+
+	data, ok := cm.Data[deletionsKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var deletions []time.Time
+	if err := json.Unmarshal([]byte(data), &deletions); err != nil {
+		return nil, fmt.Errorf("could not parse deletions in configmap %s/%s: %v", l.Namespace, l.Name, err)
+	}
+
+	return deletions, nil
+}
+
+func (l *ConfigMapDeletionLog) Save(ctx context.Context, deletions []time.Time) error {
+	data, err := json.Marshal(deletions)
+	if err != nil {
+		return err
+	}
+
+	configMaps := l.client.CoreV1().ConfigMaps(l.Namespace)
+	cm, err := configMaps.Get(ctx, l.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: l.Namespace, Name: l.Name},
+			Data:       map[string]string{deletionsKey: string(data)},
+		}
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	} else if err == nil {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[deletionsKey] = string(data)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("could not save deletions to configmap %s/%s: %v", l.Namespace, l.Name, err)
+	}
+
+	return nil
+}
@@ -2,21 +2,39 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/vixus0/skuttle/v2/internal/logging"
 	"github.com/vixus0/skuttle/v2/internal/provider"
 
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 )
 
 var (
 	log *logging.Logger = logging.NewLogger("ctrl")
+
+	verdicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "skuttle_provider_verdicts_total",
+			Help: "Verdicts on NotReady nodes' instances, by provider prefix and state.",
+		},
+		[]string{"prefix", "state"},
+	)
 )
 
+func init() {
+	prometheus.MustRegister(verdicts)
+}
+
 type NodeDeleter interface {
 	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
 }
@@ -25,12 +43,51 @@ type Controller struct {
 	Config
 	nodeDeleter NodeDeleter
 	ctx         context.Context
+
+	// names of nodes to reconcile
+	queue workqueue.RateLimitingInterface
+	// names of deleted nodes whose volumes still need cleaning up, and
+	// what's needed to clean them up
+	cleanups     workqueue.RateLimitingInterface
+	cleanupNodes map[string]volumeCleanup
+	indexer      cache.Indexer
+	synced       cache.InformerSynced
+	shutdown     sync.Once
+
+	// nodes waiting to be checked in the next batch, and verdicts from
+	// the last batch waiting to be acted on by the workers, by name
+	mu       sync.Mutex
+	pending  map[string]*node
+	verdicts map[string]batchVerdict
+
+	// when nodes were deleted, for the deletion budget, and whether they
+	// have been loaded from the deletion log
+	deletionsMu     sync.Mutex
+	deletions       []time.Time
+	deletionsLoaded bool
 }
 
 type Config struct {
 	DryRun           bool
 	NotReadyDuration time.Duration
 	Providers        provider.Store
+	// How long to collect nodes for before checking them together, or zero
+	// to check each node as it is handled
+	BatchWindow time.Duration
+	// Nodes reconciled at once, defaults to 1
+	Workers int
+	// Backoff before retrying a node after an error, doubling with each
+	// consecutive error up to RetryMaxDelay
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// Limits on deleting nodes
+	Safety SafetyConfig
+	// Records events on nodes if set
+	Recorder record.EventRecorder
+	// Clears nodes of pods before they're deleted if set
+	Drainer Drainer
+	// Cleans up after nodes' volumes once they're deleted if set
+	VolumeCleaner VolumeCleaner
 }
 
 func NewController(
@@ -39,10 +96,33 @@ func NewController(
 	nodeDeleter NodeDeleter,
 	nodeInformer cache.SharedIndexInformer,
 ) *Controller {
+	baseDelay := cfg.RetryBaseDelay
+	if baseDelay <= 0 {
+		baseDelay = time.Second
+	}
+
+	maxDelay := cfg.RetryMaxDelay
+	if maxDelay < baseDelay {
+		maxDelay = 5 * time.Minute
+	}
+
 	controller := &Controller{
 		Config:      *cfg,
 		ctx:         ctx,
 		nodeDeleter: nodeDeleter,
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+			"nodes",
+		),
+		cleanups: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+			"volume-cleanups",
+		),
+		cleanupNodes: map[string]volumeCleanup{},
+		indexer:      nodeInformer.GetIndexer(),
+		synced:       nodeInformer.HasSynced,
+		pending:      map[string]*node{},
+		verdicts:     map[string]batchVerdict{},
 	}
 
 	nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -58,73 +138,416 @@ func NewController(
 func (c *Controller) Add(obj interface{}) {
 	n := coerce(obj)
 	log.Debug("add node %s", n.Name())
-	if err := c.Handle(n); err != nil {
-		log.Error(err.Error())
-	}
+	c.queue.Add(n.Name())
 }
 
 // When a node gets updated
 func (c *Controller) Update(_ interface{}, obj interface{}) {
 	n := coerce(obj)
 	log.Debug("update node %s", n.Name())
-	if err := c.Handle(n); err != nil {
-		log.Error(err.Error())
-	}
+	c.queue.Add(n.Name())
 }
 
 // When a node gets deleted
 func (c *Controller) Delete(obj interface{}) {
-	n := coerce(obj)
-	log.Debug("remove node %s", n.Name())
+	name, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	log.Debug("remove node %s", name)
+
+	c.mu.Lock()
+	delete(c.pending, name)
+	delete(c.verdicts, name)
+	c.mu.Unlock()
+
+	c.queue.Forget(name)
+}
+
+// Run reconciles queued nodes with Workers workers once the informer has
+// synced, until the context is done
+func (c *Controller) Run() {
+	defer c.ShutDown()
+
+	log.Info("wait for sync")
+	if !cache.WaitForCacheSync(c.ctx.Done(), c.synced) {
+		log.Error("timed out waiting for node cache to sync")
+		return
+	}
+
+	if c.BatchWindow > 0 {
+		go c.runBatches()
+	}
+
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	log.Info("starting %d workers", workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.processNextItem() {
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for c.processNextCleanup() {
+		}
+	}()
+
+	<-c.ctx.Done()
+	c.ShutDown()
+	wg.Wait()
+	log.Info("stopped")
+}
+
+// ShutDown stops the workers once they finish the nodes they are handling
+func (c *Controller) ShutDown() {
+	c.shutdown.Do(func() {
+		c.queue.ShutDown()
+		c.cleanups.ShutDown()
+	})
+}
+
+// Reconcile the next queued node, returning false once the queue is shut down
+func (c *Controller) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	name := key.(string)
+
+	obj, exists, err := c.indexer.GetByKey(name)
+	if err != nil {
+		log.Error("could not get node %s: %v", name, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	// node was deleted since it was queued
+	if !exists {
+		c.queue.Forget(key)
+		return true
+	}
+
+	requeueAfter, err := c.Handle(coerce(obj))
+	switch {
+	case err != nil:
+		log.Error("error handling node %s, retry %d: %v", name, c.queue.NumRequeues(key)+1, err)
+		c.queue.AddRateLimited(key)
+	case requeueAfter > 0:
+		c.queue.Forget(key)
+		c.queue.AddAfter(key, requeueAfter)
+	case c.isPending(name):
+		// keep backing off until the batch is checked
+	default:
+		c.queue.Forget(key)
+	}
+
+	return true
 }
 
-// Handle a node
-func (c *Controller) Handle(n *node) error {
+// Handle a node, returning how long until it should be handled again if it
+// is NotReady but still within NotReadyDuration
+func (c *Controller) Handle(n *node) (time.Duration, error) {
+	overdue, requeueAfter, err := c.overdue(n)
+	if err != nil {
+		return 0, err
+	}
+
+	// node is Ready or within the threshold, so neither the next batch nor
+	// the last one should decide what happens to it
+	c.mu.Lock()
+	batched, ok := c.verdicts[n.Name()]
+	delete(c.verdicts, n.Name())
+	if !overdue {
+		delete(c.pending, n.Name())
+	}
+	c.mu.Unlock()
+
+	if !overdue {
+		return requeueAfter, nil
+	}
+
+	p, err := c.Providers.Get(prefix(n))
+	if err != nil {
+		return 0, err
+	}
+
+	// Act on the verdict from the last batch, unless the node has been
+	// given another instance since
+	if ok && batched.providerID == n.ProviderID() {
+		return 0, c.handleVerdict(p, prefix(n), n, batched.verdict)
+	}
+
+	if c.BatchWindow > 0 {
+		c.mu.Lock()
+		c.pending[n.Name()] = n
+		c.mu.Unlock()
+		return 0, nil
+	}
+
+	// Ask the provider what became of the instance
+	verdict, err := p.InstanceVerdict(n.ProviderID())
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, c.handleVerdict(p, prefix(n), n, verdict)
+}
+
+// A verdict on a node's instance from a batch
+type batchVerdict struct {
+	providerID string
+	verdict    provider.Verdict
+}
+
+// Whether a node has been NotReady for longer than NotReadyDuration, and if
+// it is NotReady but within the threshold, how long until it won't be
+func (c *Controller) overdue(n *node) (bool, time.Duration, error) {
 	cond, err := n.ReadyCondition()
 	if err != nil {
-		return err
+		return false, 0, err
 	}
 
 	// node is Ready, no need to handle
 	if cond.Status == v1.ConditionTrue {
-		return nil
+		return false, 0, nil
 	}
 
 	// handle if transition to NotReady is greater than tolerance
 	sinceTransition := time.Since(cond.LastTransitionTime.Time)
 	threshold := c.NotReadyDuration
 
-	if sinceTransition > threshold {
-		log.Info(
-			"node %s has been NotReady for %s (> threshold %s)",
-			n.Name(),
-			sinceTransition.String(),
-			threshold.String(),
-		)
-
-		// Get Provider for Node
-		prefixParts := strings.Split(n.ProviderID(), ":")
-		prefix := prefixParts[0]
-		provider, err := c.Providers.Get(prefix)
-
-		// Check if instance exists
-		exists, err := provider.InstanceExists(n.ProviderID())
-		if err != nil {
+	// check again just after the node passes the threshold
+	if sinceTransition <= threshold {
+		return false, threshold - sinceTransition + time.Millisecond, nil
+	}
+
+	log.Info(
+		"node %s has been NotReady for %s (> threshold %s)",
+		n.Name(),
+		sinceTransition.String(),
+		threshold.String(),
+	)
+
+	return true, 0, nil
+}
+
+// Delete node only if the provider is sure its instance is gone
+func (c *Controller) handleVerdict(p provider.Provider, prefix string, n *node, verdict provider.Verdict) error {
+	verdicts.WithLabelValues(prefix, string(verdict.State)).Inc()
+
+	switch verdict.State {
+	case provider.Gone:
+		log.Info("instance of node %s is gone: %s", n.Name(), describe(verdict))
+	case provider.Exists:
+		log.Warn("node %s exists at provider: %s", n.Name(), describe(verdict))
+		return nil
+	case provider.Unknown:
+		log.Warn("provider can't tell if node %s exists, leaving it alone: %s", n.Name(), describe(verdict))
+		return nil
+	default:
+		return fmt.Errorf("provider %s returned unknown verdict %q for node %s", prefix, verdict.State, n.Name())
+	}
+
+	reserved, err := c.reserveDeletion(n)
+	if err != nil {
+		return err
+	}
+
+	if c.Drainer != nil {
+		if c.DryRun {
+			log.Info("*** DRY RUN *** drained node %s", n.Name())
+		} else if err := c.Drainer.Drain(c.ctx, n.Node); err != nil {
+			c.releaseDeletion(reserved)
 			return err
 		}
+	}
 
-		// Delete node if not
-		if exists {
-			log.Warn("node %s exists at provider", n.Name())
+	if deleter, ok := p.(provider.InstanceDeleter); ok {
+		if c.DryRun {
+			log.Info("*** DRY RUN *** deleted instance %s", n.ProviderID())
+		} else if err := deleter.DeleteInstance(n.ProviderID()); err != nil {
+			c.releaseDeletion(reserved)
+			return err
+		}
+	}
+
+	log.Info("deleting node %s", n.Name())
+	c.event(n, v1.EventTypeNormal, "InstanceGone", "Deleting node: %s", describe(verdict))
+	if err := c.deleteNode(n.Name()); err != nil {
+		c.releaseDeletion(reserved)
+		return err
+	}
+
+	if c.VolumeCleaner != nil {
+		if c.DryRun {
+			log.Info("*** DRY RUN *** cleaned up volumes of node %s", n.Name())
 		} else {
-			log.Info("deleting node %s", n.Name())
-			return c.deleteNode(n.Name())
+			// The node is gone, so it won't be handled again and its volumes
+			// need retrying separately
+			c.mu.Lock()
+			c.cleanupNodes[n.Name()] = volumeCleanup{node: n.Node, provider: p}
+			c.mu.Unlock()
+			c.cleanups.Add(n.Name())
 		}
 	}
 
 	return nil
 }
 
+// A deleted node and its provider, to clean up its volumes with
+type volumeCleanup struct {
+	node     *v1.Node
+	provider provider.Provider
+}
+
+// Clean up the volumes of the next deleted node, returning false once the
+// queue is shut down
+func (c *Controller) processNextCleanup() bool {
+	key, quit := c.cleanups.Get()
+	if quit {
+		return false
+	}
+	defer c.cleanups.Done(key)
+
+	name := key.(string)
+
+	c.mu.Lock()
+	cleanup, ok := c.cleanupNodes[name]
+	c.mu.Unlock()
+	if !ok {
+		c.cleanups.Forget(key)
+		return true
+	}
+
+	// a new node has taken the name, so the attachments are its own
+	if obj, exists, _ := c.indexer.GetByKey(name); exists && coerce(obj).UID != cleanup.node.UID {
+		log.Warn("node %s was recreated, no longer cleaning up its volumes", name)
+		c.mu.Lock()
+		delete(c.cleanupNodes, name)
+		c.mu.Unlock()
+		c.cleanups.Forget(key)
+		return true
+	}
+
+	err := c.VolumeCleaner.CleanUp(c.ctx, cleanup.node, cleanup.provider)
+	if errors.Is(err, ErrVolumeAttached) {
+		log.Info("waiting for volumes of node %s to detach, retry %d: %v", name, c.cleanups.NumRequeues(key)+1, err)
+		c.cleanups.AddRateLimited(key)
+		return true
+	}
+	if err != nil {
+		log.Error("could not clean up volumes of node %s, retry %d: %v", name, c.cleanups.NumRequeues(key)+1, err)
+		c.cleanups.AddRateLimited(key)
+		return true
+	}
+
+	c.mu.Lock()
+	delete(c.cleanupNodes, name)
+	c.mu.Unlock()
+	c.cleanups.Forget(key)
+
+	return true
+}
+
+// Check pending nodes every batch window until the context is done
+func (c *Controller) runBatches() {
+	ticker := time.NewTicker(c.BatchWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.Flush()
+		}
+	}
+}
+
+// Flush checks all pending nodes, asking each provider about its nodes
+// in one go, and queues them for the workers to act on the verdicts. Nodes
+// which can't be checked are queued again with backoff.
+func (c *Controller) Flush() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = map[string]*node{}
+	c.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	byPrefix := map[string][]*node{}
+	for _, n := range pending {
+		byPrefix[prefix(n)] = append(byPrefix[prefix(n)], n)
+	}
+
+	for prefix, nodes := range byPrefix {
+		p, err := c.Providers.Get(prefix)
+		if err != nil {
+			log.Error(err.Error())
+			c.retry(nodes)
+			continue
+		}
+
+		providerIDs := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			providerIDs = append(providerIDs, n.ProviderID())
+		}
+
+		log.Debug("checking %d nodes with provider %s", len(nodes), prefix)
+		found, err := provider.InstanceVerdicts(p, providerIDs)
+		if err != nil {
+			log.Error(err.Error())
+			c.retry(nodes)
+			continue
+		}
+
+		for _, n := range nodes {
+			verdict, ok := found[n.ProviderID()]
+			if !ok {
+				log.Error("provider %s returned no answer for node %s", prefix, n.Name())
+				c.retry([]*node{n})
+				continue
+			}
+
+			// the workers check the node is still overdue, since it may
+			// have recovered while the provider was being asked
+			c.mu.Lock()
+			c.verdicts[n.Name()] = batchVerdict{providerID: n.ProviderID(), verdict: verdict}
+			c.mu.Unlock()
+			c.queue.Add(n.Name())
+		}
+	}
+}
+
+func (c *Controller) isPending(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.pending[name]
+	return ok
+}
+
+// Queue nodes again after their backoff
+func (c *Controller) retry(nodes []*node) {
+	for _, n := range nodes {
+		c.queue.AddRateLimited(n.Name())
+	}
+}
+
 func (c *Controller) deleteNode(name string) error {
 	if c.DryRun {
 		log.Info("*** DRY RUN *** deleted node %s", name)
@@ -134,6 +557,30 @@ func (c *Controller) deleteNode(name string) error {
 	return nil
 }
 
+// Record an event on a node if there is a recorder
+func (c *Controller) event(n *node, eventType, reason, messageFmt string, args ...interface{}) {
+	if c.Recorder != nil {
+		c.Recorder.Eventf(n.Node, eventType, reason, messageFmt, args...)
+	}
+}
+
+// Describe a verdict for logs
+func describe(verdict provider.Verdict) string {
+	desc := verdict.Reason
+	if desc == "" {
+		desc = "no reason given"
+	}
+	if verdict.ProviderState != "" {
+		desc = fmt.Sprintf("%s (provider state %s)", desc, verdict.ProviderState)
+	}
+	return desc
+}
+
+// Get the provider prefix of a node, such as aws
+func prefix(n *node) string {
+	return strings.Split(n.ProviderID(), ":")[0]
+}
+
 func coerce(obj interface{}) *node {
 	v1node := obj.(*v1.Node)
 	return &node{v1node}
@@ -1,13 +1,108 @@
 package controller_test
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"github.com/vixus0/skuttle/v2/internal/controller"
+	"github.com/vixus0/skuttle/v2/internal/provider"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestController(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Controller Suite")
 }
+
+// Fixture is a fake cluster for a controller to run against. Specs change
+// the config or provider before calling Start.
+type Fixture struct {
+	Client       *fake.Clientset
+	Config       *controller.Config
+	Provider     provider.Provider
+	DeletedNodes chan string
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	deleted []string
+}
+
+// NewFixture sets up an empty cluster, with a config which deletes nodes
+// NotReady for over ten minutes if the provider says they are gone
+func NewFixture(p provider.Provider) *Fixture {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Fixture{
+		Client: fake.NewSimpleClientset(),
+		Config: &controller.Config{
+			NotReadyDuration: 10 * time.Minute,
+		},
+		Provider:     p,
+		DeletedNodes: make(chan string, 10),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// AddNodes adds nodes which have been Ready or NotReady for fifteen minutes
+func (f *Fixture) AddNodes(ready bool, names ...string) {
+	for _, name := range names {
+		AddNode(f.Client, FakeNode{
+			Name:           name,
+			Ready:          ready,
+			TransitionTime: time.Now().Add(-15 * time.Minute),
+		})
+	}
+}
+
+// NewController sets up a controller with its informer synced, sending the
+// names of deleted nodes to DeletedNodes
+func (f *Fixture) NewController() *controller.Controller {
+	providerStore := &provider.DefaultStore{}
+	if f.Provider != nil {
+		providerStore.Add("fake", f.Provider)
+	}
+	f.Config.Providers = providerStore
+
+	factory := informers.NewSharedInformerFactory(f.Client, 0)
+	nodeInformer := factory.Core().V1().Nodes().Informer()
+	nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			f.DeletedNodes <- obj.(*v1.Node).ObjectMeta.Name
+		},
+	})
+
+	ctrl := controller.NewController(f.Config, f.ctx, f.Client.CoreV1().Nodes(), nodeInformer)
+	factory.Start(f.ctx.Done())
+	cache.WaitForCacheSync(f.ctx.Done(), nodeInformer.HasSynced)
+	return ctrl
+}
+
+// Start runs a controller in the background until Stop is called
+func (f *Fixture) Start() {
+	go f.NewController().Run()
+}
+
+func (f *Fixture) Stop() {
+	f.cancel()
+}
+
+// Deleted gives the names of all the nodes deleted so far, for specs which
+// don't care about the order
+func (f *Fixture) Deleted() []string {
+	for {
+		select {
+		case name := <-f.DeletedNodes:
+			f.deleted = append(f.deleted, name)
+		default:
+			return f.deleted
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/vixus0/skuttle/v2/internal/controller"
@@ -15,137 +16,473 @@ import (
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	//"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/fake"
-	//clienttesting "k8s.io/client-go/testing"
-	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 var _ = Describe("Controller", func() {
-	var (
-		client       kubernetes.Interface
-		deletedNodes []string
-	)
+	var f *Fixture
 
 	logging.SetLevel(logging.DEBUG)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Create the fake client.
-	client = fake.NewSimpleClientset()
-
-	// We will create an informer that writes added nodes to a channel.
-	factory := informers.NewSharedInformerFactory(client, 0)
+	BeforeEach(func() {
+		f = NewFixture(&FakeProvider{
+			Nodes: map[string]bool{
+				"node-ready":           true,
+				"node-unready-below":   false,
+				"node-unready-exists":  true,
+				"node-unready-missing": false,
+			},
+		})
 
-	// Create node informer that will also collect deleted nodes
-	nodeInformer := factory.Core().V1().Nodes().Informer()
-	nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		DeleteFunc: func(obj interface{}) {
-			node := obj.(*v1.Node)
-			deletedNodes = append(deletedNodes, node.ObjectMeta.Name)
-		},
+		AddNode(f.Client, FakeNode{
+			Name:           "node-ready",
+			Ready:          true,
+			TransitionTime: time.Now(),
+		})
+		AddNode(f.Client, FakeNode{
+			Name:           "node-unready-below",
+			Ready:          false,
+			TransitionTime: time.Now().Add(-5 * time.Minute),
+		})
+		f.AddNodes(false, "node-unready-exists", "node-unready-missing", "node-error")
+		f.Start()
 	})
 
-	// populate fake cloud provider
-	fakeProvider := &FakeProvider{
-		Nodes: map[string]bool{
-			"node-ready":           true,
-			"node-unready-below":   false,
-			"node-unready-above":   false,
-			"node-unready-exists":  true,
-			"node-unready-missing": false,
-		},
-	}
-
-	// Setup controller
-	providerStore := &provider.DefaultStore{}
-	providerStore.Add("fake", fakeProvider)
-	cfg := &controller.Config{
-		DryRun:           false,
-		NotReadyDuration: 10 * time.Minute,
-		Providers:        providerStore,
-	}
-
-	// add nodes to kube API
-	AddNode(client, FakeNode{
-		Name:           "node-ready",
-		Ready:          true,
-		TransitionTime: time.Now(),
-	})
-	AddNode(client, FakeNode{
-		Name:           "node-unready-below",
-		Ready:          false,
-		TransitionTime: time.Now().Add(-5 * time.Minute),
+	AfterEach(func() {
+		f.Stop()
 	})
-	AddNode(client, FakeNode{
-		Name:           "node-unready-exists",
-		Ready:          false,
-		TransitionTime: time.Now().Add(-15 * time.Minute),
-	})
-	AddNode(client, FakeNode{
-		Name:           "node-unready-missing",
-		Ready:          false,
-		TransitionTime: time.Now().Add(-15 * time.Minute),
-	})
-	AddNode(client, FakeNode{
-		Name:           "node-error",
-		Ready:          false,
-		TransitionTime: time.Now().Add(-20 * time.Minute),
-	})
-
-	// run controller
-	controller.NewController(cfg, ctx, client.CoreV1().Nodes(), nodeInformer)
-	factory.Start(ctx.Done())
-	cache.WaitForCacheSync(ctx.Done(), nodeInformer.HasSynced)
 
 	Describe("Handle node", func() {
 		Context("Node is ready", func() {
 			It("Should do nothing", func() {
-				Expect(deletedNodes).ToNot(ContainElement("node-ready"))
+				Consistently(f.Deleted).ShouldNot(ContainElement("node-ready"))
 			})
 		})
 
 		Context("Node is not ready for shorter than threshold", func() {
 			It("Should do nothing", func() {
-				Expect(deletedNodes).ToNot(ContainElement("node-unready-below"))
+				Consistently(f.Deleted).ShouldNot(ContainElement("node-unready-below"))
 			})
 		})
 
 		Context("Node is not ready for longer than threshold", func() {
 			Context("Node exists at provider", func() {
 				It("Should do nothing", func() {
-					Expect(deletedNodes).ToNot(ContainElement("node-unready-exists"))
+					Consistently(f.Deleted).ShouldNot(ContainElement("node-unready-exists"))
 				})
 			})
 
 			Context("Node does not exist at provider", func() {
 				It("Should delete the node", func() {
-					Expect(deletedNodes).To(ContainElement("node-unready-missing"))
+					Eventually(f.Deleted).Should(ContainElement("node-unready-missing"))
 				})
 			})
 		})
 
 		Context("Provider returns error", func() {
 			It("Should return the error", func() {
-				Expect(deletedNodes).ToNot(ContainElement("node-unready-exists"))
+				Consistently(f.Deleted).ShouldNot(ContainElement("node-error"))
 			})
 		})
 	})
 })
 
+var _ = Describe("Controller with batching", func() {
+	var (
+		f            *Fixture
+		fakeProvider *FakeBatchProvider
+	)
+
+	BeforeEach(func() {
+		fakeProvider = &FakeBatchProvider{
+			FakeProvider: FakeProvider{
+				Nodes: map[string]bool{
+					"node-exists":    true,
+					"node-missing-1": false,
+					"node-missing-2": false,
+				},
+			},
+		}
+		f = NewFixture(fakeProvider)
+		f.Config.BatchWindow = 500 * time.Millisecond
+		f.AddNodes(false, "node-exists", "node-missing-1", "node-missing-2")
+	})
+
+	AfterEach(func() {
+		f.Stop()
+	})
+
+	It("Should check pending nodes together", func() {
+		f.Start()
+
+		var first, second string
+		Eventually(f.DeletedNodes, 2*time.Second).Should(Receive(&first))
+		Eventually(f.DeletedNodes).Should(Receive(&second))
+		Expect([]string{first, second}).To(ConsistOf("node-missing-1", "node-missing-2"))
+		Consistently(f.DeletedNodes).ShouldNot(Receive())
+		Expect(fakeProvider.Batches()).To(Equal([]int{3}))
+	})
+
+	It("Should not delete nodes which become Ready before the batch is checked", func() {
+		f.Start()
+
+		// wait for the node to be pending, but not for the batch window
+		time.Sleep(250 * time.Millisecond)
+
+		ctx := context.TODO()
+		node, err := f.Client.CoreV1().Nodes().Get(ctx, "node-missing-1", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		node.Status.Conditions[0].Status = v1.ConditionTrue
+		node.Status.Conditions[0].LastTransitionTime = metav1.Now()
+		_, err = f.Client.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{})
+		Expect(err).To(BeNil())
+
+		var deleted string
+		Eventually(f.DeletedNodes, 2*time.Second).Should(Receive(&deleted))
+		Expect(deleted).To(Equal("node-missing-2"))
+		Consistently(f.DeletedNodes).ShouldNot(Receive())
+	})
+
+	It("Should leave acting on the verdicts to the workers", func() {
+		drainer := &FakeBlockingDrainer{Block: "node-missing-1", release: make(chan struct{})}
+		defer close(drainer.release)
+		f.Config.Drainer = drainer
+		f.Config.Workers = 2
+		fakeProvider.Nodes["node-missing-3"] = false
+		f.Start()
+
+		// one node being slow to drain holds up neither the other nor
+		// the next batch
+		Eventually(f.DeletedNodes, 2*time.Second).Should(Receive(Equal("node-missing-2")))
+		f.AddNodes(false, "node-missing-3")
+		Eventually(f.DeletedNodes, 2*time.Second).Should(Receive(Equal("node-missing-3")))
+	})
+})
+
+var _ = Describe("Controller with an instance deleter", func() {
+	var (
+		f            *Fixture
+		fakeProvider *FakeDeleterProvider
+	)
+
+	BeforeEach(func() {
+		fakeProvider = &FakeDeleterProvider{
+			FakeProvider: FakeProvider{
+				Nodes: map[string]bool{
+					"node-exists":  true,
+					"node-missing": false,
+				},
+			},
+		}
+		f = NewFixture(fakeProvider)
+		f.AddNodes(false, "node-exists", "node-missing")
+	})
+
+	AfterEach(func() {
+		f.Stop()
+	})
+
+	It("Should delete the instance along with the node", func() {
+		f.Start()
+		Eventually(fakeProvider.Deleted).Should(Equal([]string{"fake://node-missing"}))
+	})
+
+	It("Should not delete the instance in dry run mode", func() {
+		f.Config.DryRun = true
+		f.Start()
+		Consistently(fakeProvider.Deleted).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("Controller with a provider which can't tell", func() {
+	var f *Fixture
+
+	BeforeEach(func() {
+		f = NewFixture(&FakeVerdictProvider{
+			Verdicts: map[string]provider.State{
+				"node-unknown": provider.Unknown,
+				"node-gone":    provider.Gone,
+			},
+		})
+		f.AddNodes(false, "node-unknown", "node-gone")
+		f.Start()
+	})
+
+	AfterEach(func() {
+		f.Stop()
+	})
+
+	It("Should only delete nodes which are definitely gone", func() {
+		Eventually(f.DeletedNodes).Should(Receive(Equal("node-gone")))
+		Consistently(f.DeletedNodes).ShouldNot(Receive())
+	})
+})
+
+var _ = Describe("Controller workqueue", func() {
+	var f *Fixture
+
+	BeforeEach(func() {
+		f = NewFixture(&FakeProvider{Nodes: map[string]bool{"node-missing": false}})
+	})
+
+	AfterEach(func() {
+		f.Stop()
+	})
+
+	It("Should delete a node once it has been NotReady for long enough", func() {
+		f.Config.NotReadyDuration = 500 * time.Millisecond
+		AddNode(f.Client, FakeNode{
+			Name:           "node-missing",
+			Ready:          false,
+			TransitionTime: time.Now(),
+		})
+		f.Start()
+
+		Consistently(f.DeletedNodes, 300*time.Millisecond).ShouldNot(Receive())
+		Eventually(f.DeletedNodes, 2*time.Second).Should(Receive(Equal("node-missing")))
+	})
+
+	It("Should retry nodes after errors", func() {
+		flaky := &FakeFlakyProvider{
+			FakeProvider: FakeProvider{Nodes: map[string]bool{"node-missing": false}},
+			Failures:     3,
+		}
+		f.Provider = flaky
+		f.Config.RetryBaseDelay = 10 * time.Millisecond
+		f.AddNodes(false, "node-missing")
+		f.Start()
+
+		Eventually(f.DeletedNodes, 2*time.Second).Should(Receive(Equal("node-missing")))
+		Expect(flaky.Calls()).To(Equal(4))
+	})
+
+	It("Should stop when the context is done", func() {
+		f.Config.Workers = 3
+		ctrl := f.NewController()
+
+		stopped := make(chan struct{})
+		go func() {
+			ctrl.Run()
+			close(stopped)
+		}()
+
+		f.Stop()
+		Eventually(stopped).Should(BeClosed())
+	})
+})
+
+var _ = Describe("Controller safety policy", func() {
+	var (
+		f        *Fixture
+		recorder *record.FakeRecorder
+	)
+
+	BeforeEach(func() {
+		f = NewFixture(&FakeProvider{
+			Nodes: map[string]bool{
+				"node-ready-1":   true,
+				"node-ready-2":   true,
+				"node-missing-1": false,
+				"node-missing-2": false,
+			},
+		})
+		recorder = record.NewFakeRecorder(10)
+		f.Config.RetryBaseDelay = time.Minute
+		f.Config.Recorder = recorder
+		f.AddNodes(true, "node-ready-1", "node-ready-2")
+		f.AddNodes(false, "node-missing-1", "node-missing-2")
+	})
+
+	AfterEach(func() {
+		f.Stop()
+	})
+
+	It("Should not delete nodes while too many are NotReady", func() {
+		f.Config.Safety.MaxNotReady = 1
+		f.Start()
+
+		Eventually(recorder.Events).Should(Receive(ContainSubstring("DeletionBlocked")))
+		Consistently(f.DeletedNodes).ShouldNot(Receive())
+	})
+
+	It("Should not delete nodes while too large a share are NotReady", func() {
+		f.Config.Safety.MaxNotReadyPercent = 40
+		f.Start()
+
+		Eventually(recorder.Events).Should(Receive(ContainSubstring("DeletionBlocked")))
+		Consistently(f.DeletedNodes).ShouldNot(Receive())
+	})
+
+	It("Should delete nodes while few enough are NotReady", func() {
+		f.Config.Safety.MaxNotReady = 2
+		f.Config.Safety.MaxNotReadyPercent = 50
+		f.Start()
+
+		Eventually(f.DeletedNodes).Should(Receive())
+		Eventually(f.DeletedNodes).Should(Receive())
+	})
+
+	It("Should stop deleting nodes once the hourly budget is spent", func() {
+		f.Config.Safety.MaxDeletionsPerHour = 1
+		f.Start()
+
+		Eventually(f.DeletedNodes).Should(Receive())
+		Consistently(f.DeletedNodes).ShouldNot(Receive())
+		Eventually(recorder.Events).Should(Receive(ContainSubstring("DeletionBlocked")))
+	})
+
+	It("Should not spend the budget in dry run mode", func() {
+		f.Config.DryRun = true
+		f.Config.Safety.MaxDeletionsPerHour = 1
+		f.Start()
+
+		Eventually(recorder.Events).Should(Receive(ContainSubstring("InstanceGone")))
+		Eventually(recorder.Events).Should(Receive(ContainSubstring("InstanceGone")))
+		Consistently(recorder.Events).ShouldNot(Receive(ContainSubstring("DeletionBlocked")))
+	})
+
+	It("Should record deletions in the deletion log", func() {
+		deletionLog := controller.NewConfigMapDeletionLog(f.Client, "kube-system", "skuttle-deletions")
+		f.Config.Safety.MaxDeletionsPerHour = 5
+		f.Config.Safety.DeletionLog = deletionLog
+		f.Start()
+
+		Eventually(f.DeletedNodes).Should(Receive())
+		Eventually(f.DeletedNodes).Should(Receive())
+		Eventually(func() ([]time.Time, error) { return deletionLog.Load(context.TODO()) }).Should(HaveLen(2))
+	})
+
+	It("Should count deletions from before it started", func() {
+		deletionLog := controller.NewConfigMapDeletionLog(f.Client, "kube-system", "skuttle-deletions")
+		Expect(deletionLog.Save(context.TODO(), []time.Time{
+			time.Now().Add(-2 * time.Hour),
+			time.Now().Add(-10 * time.Minute),
+		})).To(Succeed())
+		f.Config.Safety.MaxDeletionsPerHour = 2
+		f.Config.Safety.DeletionLog = deletionLog
+		f.Start()
+
+		Eventually(f.DeletedNodes).Should(Receive())
+		Consistently(f.DeletedNodes).ShouldNot(Receive())
+		Eventually(recorder.Events).Should(Receive(ContainSubstring("DeletionBlocked")))
+	})
+})
+
 type FakeProvider struct {
 	Nodes map[string]bool
 }
 
-func (p *FakeProvider) InstanceExists(providerID string) (bool, error) {
+func (p *FakeProvider) InstanceVerdict(providerID string) (provider.Verdict, error) {
 	id := strings.TrimPrefix(providerID, "fake://")
 	if exists, ok := p.Nodes[id]; ok {
-		return exists, nil
+		return provider.VerdictFromExists(exists), nil
+	}
+	return provider.Verdict{}, fmt.Errorf("unknown provider ID: %v", providerID)
+}
+
+// FakeFlakyProvider fails a number of times before answering
+type FakeFlakyProvider struct {
+	FakeProvider
+	Failures int
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *FakeFlakyProvider) InstanceVerdict(providerID string) (provider.Verdict, error) {
+	p.mu.Lock()
+	p.calls++
+	calls := p.calls
+	p.mu.Unlock()
+
+	if calls <= p.Failures {
+		return provider.Verdict{}, fmt.Errorf("provider is having a bad day")
+	}
+	return p.FakeProvider.InstanceVerdict(providerID)
+}
+
+func (p *FakeFlakyProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// FakeBatchProvider records the size of each batch it is asked about
+type FakeBatchProvider struct {
+	FakeProvider
+
+	mu      sync.Mutex
+	batches []int
+}
+
+func (p *FakeBatchProvider) InstanceVerdicts(providerIDs []string) (map[string]provider.Verdict, error) {
+	p.mu.Lock()
+	p.batches = append(p.batches, len(providerIDs))
+	p.mu.Unlock()
+
+	verdicts := map[string]provider.Verdict{}
+	for _, id := range providerIDs {
+		verdict, err := p.InstanceVerdict(id)
+		if err != nil {
+			return nil, err
+		}
+		verdicts[id] = verdict
+	}
+	return verdicts, nil
+}
+
+func (p *FakeBatchProvider) Batches() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]int{}, p.batches...)
+}
+
+// FakeDeleterProvider records the instances it is asked to delete
+type FakeDeleterProvider struct {
+	FakeProvider
+
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (p *FakeDeleterProvider) DeleteInstance(providerID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deleted = append(p.deleted, providerID)
+	return nil
+}
+
+func (p *FakeDeleterProvider) Deleted() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.deleted...)
+}
+
+// FakeVerdictProvider gives a verdict rather than whether an instance exists
+type FakeVerdictProvider struct {
+	Verdicts map[string]provider.State
+}
+
+func (p *FakeVerdictProvider) InstanceVerdict(providerID string) (provider.Verdict, error) {
+	id := strings.TrimPrefix(providerID, "fake://")
+	if state, ok := p.Verdicts[id]; ok {
+		return provider.Verdict{State: state, Reason: "fake verdict"}, nil
+	}
+	return provider.Verdict{}, fmt.Errorf("unknown provider ID: %v", providerID)
+}
+
+// FakeBlockingDrainer doesn't finish draining one node until released
+type FakeBlockingDrainer struct {
+	Block   string
+	release chan struct{}
+}
+
+func (d *FakeBlockingDrainer) Drain(ctx context.Context, node *v1.Node) error {
+	if node.Name == d.Block {
+		<-d.release
 	}
-	return false, fmt.Errorf("unknown provider ID: %v", providerID)
+	return nil
 }
 
 type FakeNode struct {
@@ -160,7 +497,7 @@ func AddNode(client kubernetes.Interface, fn FakeNode) {
 		Spec:       v1.NodeSpec{ProviderID: fmt.Sprintf("fake://%s", fn.Name)},
 		Status: v1.NodeStatus{
 			Conditions: []v1.NodeCondition{
-				{Type: v1.NodeReady, Status: nodeCondition(fn.Ready), LastTransitionTime: metav1.Time{fn.TransitionTime}},
+				{Type: v1.NodeReady, Status: nodeCondition(fn.Ready), LastTransitionTime: metav1.Time{Time: fn.TransitionTime}},
 			},
 		},
 	}
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Drainer clears a node of pods before it is deleted
+type Drainer interface {
+	Drain(ctx context.Context, node *v1.Node) error
+}
+
+type DrainConfig struct {
+	// How long to keep trying to evict pods before force deleting them, or
+	// force delete them straight away if zero
+	Timeout time.Duration
+	// Leave pods owned by DaemonSets, which would only be recreated on the
+	// node while it exists
+	SkipDaemonSets bool
+	// How often to retry evictions refused by a PodDisruptionBudget and
+	// check whether pods have gone, defaults to a second
+	Interval time.Duration
+}
+
+// NodeDrainer cordons a node, evicts its pods and force deletes any which
+// couldn't be evicted before the timeout. Pods on a node whose instance is
+// gone can't shut down gracefully, so evictions don't wait for them to.
+type NodeDrainer struct {
+	DrainConfig
+	client kubernetes.Interface
+}
+
+func NewDrainer(client kubernetes.Interface, cfg *DrainConfig) *NodeDrainer {
+	return &NodeDrainer{
+		DrainConfig: *cfg,
+		client:      client,
+	}
+}
+
+func (d *NodeDrainer) Drain(ctx context.Context, node *v1.Node) error {
+	if err := d.cordon(ctx, node); err != nil {
+		return fmt.Errorf("could not cordon node %s: %v", node.Name, err)
+	}
+
+	pods, err := d.pods(ctx, node)
+	if err != nil {
+		return fmt.Errorf("could not list pods on node %s: %v", node.Name, err)
+	}
+
+	if len(pods) == 0 {
+		return nil
+	}
+
+	log.Info("evicting %d pods from node %s", len(pods), node.Name)
+
+	interval := d.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	// Keep evicting pods until they're all gone or we run out of time
+	remaining := pods
+	evictRemaining := func() (bool, error) {
+		var left []v1.Pod
+		for _, pod := range remaining {
+			gone, err := d.evict(ctx, pod)
+			if err != nil {
+				return false, err
+			}
+			if !gone {
+				left = append(left, pod)
+			}
+		}
+		remaining = left
+		return len(remaining) == 0, nil
+	}
+
+	done, err := evictRemaining()
+	if err == nil && !done && d.Timeout > 0 {
+		// Stop early if skuttle is shutting down or loses the lease
+		timeoutCtx, cancel := context.WithTimeout(ctx, d.Timeout)
+		err = wait.PollUntil(interval, evictRemaining, timeoutCtx.Done())
+		cancel()
+		if err == wait.ErrWaitTimeout {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(remaining) == 0 {
+		return nil
+	}
+
+	// Don't go on to force delete pods, or delete the node, for a drain
+	// which was given up on
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("stopped draining node %s: %v", node.Name, err)
+	}
+
+	log.Warn("%d pods still on node %s after %s, force deleting them", len(remaining), node.Name, d.Timeout)
+	for _, pod := range remaining {
+		if err := d.forceDelete(ctx, pod); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *NodeDrainer) cordon(ctx context.Context, node *v1.Node) error {
+	if node.Spec.Unschedulable {
+		return nil
+	}
+
+	log.Info("cordoning node %s", node.Name)
+	_, err := d.client.CoreV1().Nodes().Patch(
+		ctx,
+		node.Name,
+		types.MergePatchType,
+		[]byte(`{"spec":{"unschedulable":true}}`),
+		metav1.PatchOptions{},
+	)
+	return err
+}
+
+// The pods to remove from a node
+func (d *NodeDrainer) pods(ctx context.Context, node *v1.Node) ([]v1.Pod, error) {
+	list, err := d.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", node.Name).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []v1.Pod
+	for _, pod := range list.Items {
+		switch {
+		case pod.Spec.NodeName != node.Name:
+		case isMirror(pod):
+			// Static pods go with the node
+		case d.SkipDaemonSets && isDaemonSetPod(pod):
+		default:
+			pods = append(pods, pod)
+		}
+	}
+
+	return pods, nil
+}
+
+// Evict a pod without waiting for it to shut down, returning false if a
+// PodDisruptionBudget doesn't allow it yet
+func (d *NodeDrainer) evict(ctx context.Context, pod v1.Pod) (bool, error) {
+	gracePeriod := int64(0)
+	err := d.client.PolicyV1beta1().Evictions(pod.Namespace).Evict(ctx, &policyv1beta1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+		DeleteOptions: &metav1.DeleteOptions{
+			GracePeriodSeconds: &gracePeriod,
+		},
+	})
+
+	switch {
+	case err == nil:
+		log.Debug("evicted pod %s/%s", pod.Namespace, pod.Name)
+		return true, nil
+	case apierrors.IsNotFound(err):
+		return true, nil
+	case apierrors.IsTooManyRequests(err):
+		log.Debug("eviction of pod %s/%s not allowed yet: %v", pod.Namespace, pod.Name, err)
+		return false, nil
+	default:
+		return false, fmt.Errorf("could not evict pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+}
+
+func (d *NodeDrainer) forceDelete(ctx context.Context, pod v1.Pod) error {
+	gracePeriod := int64(0)
+	err := d.client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: &gracePeriod,
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+	return nil
+}
+
+func isMirror(pod v1.Pod) bool {
+	_, ok := pod.Annotations[v1.MirrorPodAnnotationKey]
+	return ok
+}
+
+func isDaemonSetPod(pod v1.Pod) bool {
+	owner := metav1.GetControllerOf(&pod)
+	return owner != nil && owner.Kind == "DaemonSet"
+}
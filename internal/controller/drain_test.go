@@ -0,0 +1,223 @@
+package controller_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/controller"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Drainer", func() {
+	var (
+		client  *fake.Clientset
+		drainer *controller.NodeDrainer
+		node    *v1.Node
+		ctx     context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		client = fake.NewSimpleClientset()
+
+		AddNode(client, FakeNode{
+			Name:           "node-missing",
+			Ready:          false,
+			TransitionTime: time.Now().Add(-15 * time.Minute),
+		})
+		var err error
+		node, err = client.CoreV1().Nodes().Get(ctx, "node-missing", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+
+		AddPod(client, "node-missing", "app", nil)
+		AddPod(client, "node-missing", "protected", nil)
+		AddPod(client, "node-missing", "daemon", &metav1.OwnerReference{
+			APIVersion: "apps/v1",
+			Kind:       "DaemonSet",
+			Name:       "daemon",
+			Controller: boolPtr(true),
+		})
+		AddPod(client, "node-other", "elsewhere", nil)
+
+		mirror := AddPod(client, "node-missing", "static", nil)
+		mirror.Annotations = map[string]string{v1.MirrorPodAnnotationKey: "hash"}
+		_, err = client.CoreV1().Pods("default").Update(ctx, mirror, metav1.UpdateOptions{})
+		Expect(err).To(BeNil())
+
+		EvictPods(client, "protected")
+
+		drainer = controller.NewDrainer(client, &controller.DrainConfig{
+			Timeout:        100 * time.Millisecond,
+			SkipDaemonSets: true,
+			Interval:       10 * time.Millisecond,
+		})
+	})
+
+	It("Should cordon the node", func() {
+		Expect(drainer.Drain(ctx, node)).To(Succeed())
+
+		cordoned, err := client.CoreV1().Nodes().Get(ctx, "node-missing", metav1.GetOptions{})
+		Expect(err).To(BeNil())
+		Expect(cordoned.Spec.Unschedulable).To(BeTrue())
+	})
+
+	It("Should remove pods from the node", func() {
+		Expect(drainer.Drain(ctx, node)).To(Succeed())
+
+		Expect(PodNames(client)).To(ConsistOf("daemon", "static", "elsewhere"))
+	})
+
+	It("Should evict pods before force deleting those which can't be evicted", func() {
+		Expect(drainer.Drain(ctx, node)).To(Succeed())
+
+		actions := PodActions(client)
+		Expect(actions[:4]).To(Equal([]string{
+			"patch nodes node-missing",
+			"list pods",
+			"create pods/eviction app",
+			"create pods/eviction protected",
+		}))
+		// retried until the timeout
+		Expect(actions[4 : len(actions)-1]).To(ContainElement("create pods/eviction protected"))
+		Expect(actions[len(actions)-1]).To(Equal("delete pods protected"))
+	})
+
+	It("Should give up without force deleting pods once the context is done", func() {
+		drainer.Timeout = time.Minute
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		Expect(drainer.Drain(ctx, node)).ToNot(Succeed())
+
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(PodActions(client)).ToNot(ContainElement("delete pods protected"))
+	})
+
+	It("Should drain DaemonSet pods unless told to skip them", func() {
+		drainer.SkipDaemonSets = false
+		Expect(drainer.Drain(ctx, node)).To(Succeed())
+
+		Expect(PodNames(client)).To(ConsistOf("static", "elsewhere"))
+	})
+})
+
+var _ = Describe("Controller with a drainer", func() {
+	var f *Fixture
+
+	BeforeEach(func() {
+		f = NewFixture(&FakeProvider{Nodes: map[string]bool{"node-missing": false}})
+		f.AddNodes(false, "node-missing")
+		AddPod(f.Client, "node-missing", "app", nil)
+		EvictPods(f.Client)
+	})
+
+	AfterEach(func() {
+		f.Stop()
+	})
+
+	It("Should cordon and drain the node before deleting it", func() {
+		f.Config.Drainer = controller.NewDrainer(f.Client, &controller.DrainConfig{})
+		f.Start()
+
+		Eventually(func() []string { return PodActions(f.Client) }).Should(ContainElement("delete nodes node-missing"))
+		Expect(PodActions(f.Client)).To(Equal([]string{
+			"patch nodes node-missing",
+			"list pods",
+			"create pods/eviction app",
+			"delete nodes node-missing",
+		}))
+	})
+})
+
+// Make evictions delete the pod, unless it's protected by a budget
+func EvictPods(client *fake.Clientset, protected ...string) {
+	client.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+
+		eviction := action.(clienttesting.CreateAction).GetObject().(*policyv1beta1.Eviction)
+		for _, name := range protected {
+			if eviction.Name == name {
+				return true, nil, apierrors.NewTooManyRequests("disruption budget", 1)
+			}
+		}
+
+		pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+		return true, nil, client.Tracker().Delete(pods, eviction.Namespace, eviction.Name)
+	})
+}
+
+// Describe the changes made to nodes and pods, in order
+func PodActions(client *fake.Clientset) []string {
+	var actions []string
+	for _, action := range client.Actions() {
+		resource := action.GetResource().Resource
+		if action.GetSubresource() != "" {
+			resource = fmt.Sprintf("%s/%s", resource, action.GetSubresource())
+		}
+
+		switch action.GetVerb() {
+		case "list":
+			if resource == "pods" {
+				actions = append(actions, "list pods")
+			}
+		case "patch":
+			name := action.(clienttesting.PatchAction).GetName()
+			actions = append(actions, fmt.Sprintf("patch %s %s", resource, name))
+		case "delete":
+			name := action.(clienttesting.DeleteAction).GetName()
+			actions = append(actions, fmt.Sprintf("delete %s %s", resource, name))
+		case "create":
+			obj := action.(clienttesting.CreateAction).GetObject()
+			if eviction, ok := obj.(*policyv1beta1.Eviction); ok {
+				actions = append(actions, fmt.Sprintf("create %s %s", resource, eviction.Name))
+			}
+		}
+	}
+	return actions
+}
+
+func PodNames(client *fake.Clientset) []string {
+	pods, err := client.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	Expect(err).To(BeNil())
+
+	var names []string
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	return names
+}
+
+func AddPod(client *fake.Clientset, nodeName, name string, owner *metav1.OwnerReference) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: nodeName},
+	}
+	if owner != nil {
+		pod.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+
+	pod, err := client.CoreV1().Pods("default").Create(context.TODO(), pod, metav1.CreateOptions{})
+	if err != nil {
+		Fail(fmt.Sprintf("error adding pod: %v", err))
+	}
+	return pod
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	deletionsBlocked = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "skuttle_deletions_blocked_total",
+			Help: "Node deletions refused by the safety policy, by reason.",
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(deletionsBlocked)
+}
+
+// ErrDeletionBlocked is returned when the safety policy refuses to delete
+// a node, so it is retried later
+var ErrDeletionBlocked = errors.New("deletion blocked by safety policy")
+
+// The window deletions are budgeted over
+const deletionWindow = time.Hour
+
+// SafetyConfig stops skuttle deleting large parts of the cluster when
+// something bigger than a few lost instances is going on
+type SafetyConfig struct {
+	// Most nodes which can be NotReady before deletions stop, or no limit
+	// if zero
+	MaxNotReady int
+	// Largest percentage of selected nodes which can be NotReady before
+	// deletions stop, or no limit if zero
+	MaxNotReadyPercent float64
+	// Most nodes deleted in any hour, or no limit if zero
+	MaxDeletionsPerHour int
+	// Keeps deletions for MaxDeletionsPerHour across restarts if set,
+	// otherwise they are only kept in memory
+	DeletionLog DeletionLog
+}
+
+// Reserve a deletion if the safety policy allows it, returning the time it
+// was reserved at, which must be released if the deletion fails. Dry runs
+// don't delete anything, so don't use up the budget.
+func (c *Controller) reserveDeletion(n *node) (time.Time, error) {
+	if reason, err := c.checkNotReady(); err != nil {
+		return time.Time{}, c.blockDeletion(n, reason, err)
+	}
+
+	if c.Safety.MaxDeletionsPerHour <= 0 || c.DryRun {
+		return time.Time{}, nil
+	}
+
+	c.deletionsMu.Lock()
+	defer c.deletionsMu.Unlock()
+
+	// pick up deletions made before skuttle started or took over
+	if c.Safety.DeletionLog != nil && !c.deletionsLoaded {
+		deletions, err := c.Safety.DeletionLog.Load(c.ctx)
+		if err != nil {
+			return time.Time{}, err
+		}
+		c.deletions = deletions
+		c.deletionsLoaded = true
+	}
+
+	now := time.Now()
+	recent := c.deletions[:0]
+	for _, t := range c.deletions {
+		if now.Sub(t) < deletionWindow {
+			recent = append(recent, t)
+		}
+	}
+	c.deletions = recent
+
+	if len(c.deletions) >= c.Safety.MaxDeletionsPerHour {
+		err := fmt.Errorf("%d nodes deleted in the last hour (limit %d)", len(c.deletions), c.Safety.MaxDeletionsPerHour)
+		return time.Time{}, c.blockDeletion(n, "budget", err)
+	}
+
+	c.deletions = append(c.deletions, now)
+	if err := c.saveDeletions(); err != nil {
+		c.deletions = c.deletions[:len(c.deletions)-1]
+		return time.Time{}, err
+	}
+
+	return now, nil
+}
+
+// Give back a deletion reserved at the given time, leaving other workers'
+// reservations alone
+func (c *Controller) releaseDeletion(reserved time.Time) {
+	if reserved.IsZero() {
+		return
+	}
+
+	c.deletionsMu.Lock()
+	defer c.deletionsMu.Unlock()
+
+	for i, t := range c.deletions {
+		if t.Equal(reserved) {
+			c.deletions = append(c.deletions[:i], c.deletions[i+1:]...)
+			break
+		}
+	}
+	if err := c.saveDeletions(); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// Write deletions to the deletion log, if there is one
+func (c *Controller) saveDeletions() error {
+	if c.Safety.DeletionLog == nil {
+		return nil
+	}
+	return c.Safety.DeletionLog.Save(c.ctx, c.deletions)
+}
+
+// Check how many of the selected nodes are NotReady, returning the reason
+// deletions should stop if there are too many
+func (c *Controller) checkNotReady() (string, error) {
+	if c.Safety.MaxNotReady <= 0 && c.Safety.MaxNotReadyPercent <= 0 {
+		return "", nil
+	}
+
+	objs := c.indexer.List()
+	notReady := 0
+	for _, obj := range objs {
+		cond, err := coerce(obj).ReadyCondition()
+		if err != nil || cond.Status != v1.ConditionTrue {
+			notReady++
+		}
+	}
+
+	if c.Safety.MaxNotReady > 0 && notReady > c.Safety.MaxNotReady {
+		return "not-ready-count", fmt.Errorf("%d nodes are NotReady (limit %d)", notReady, c.Safety.MaxNotReady)
+	}
+
+	if c.Safety.MaxNotReadyPercent > 0 && len(objs) > 0 {
+		percent := float64(notReady) / float64(len(objs)) * 100
+		if percent > c.Safety.MaxNotReadyPercent {
+			return "not-ready-percent", fmt.Errorf(
+				"%d of %d nodes (%.0f%%) are NotReady (limit %g%%)",
+				notReady,
+				len(objs),
+				percent,
+				c.Safety.MaxNotReadyPercent,
+			)
+		}
+	}
+
+	return "", nil
+}
+
+func (c *Controller) blockDeletion(n *node, reason string, err error) error {
+	deletionsBlocked.WithLabelValues(reason).Inc()
+	log.Warn("not deleting node %s: %v", n.Name(), err)
+	c.event(n, v1.EventTypeWarning, "DeletionBlocked", "Not deleting node: %v", err)
+	return fmt.Errorf("%w for node %s: %v", ErrDeletionBlocked, n.Name(), err)
+}
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vixus0/skuttle/v2/internal/provider"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrVolumeAttached is returned while the provider says a volume is still
+// attached, so the clean up is retried until it has detached
+var ErrVolumeAttached = errors.New("volume is still attached")
+
+// VolumeCleaner cleans up after the volumes of a deleted node
+type VolumeCleaner interface {
+	CleanUp(ctx context.Context, node *v1.Node, p provider.Provider) error
+}
+
+type VolumeAttachmentConfig struct {
+	// Remove the finalizers of attachments once the provider confirms their
+	// volume is detached, rather than waiting for the CSI attacher
+	RemoveFinalizers bool
+}
+
+// VolumeAttachmentCleaner deletes the VolumeAttachments of a deleted node,
+// so its volumes can be attached to other nodes straight away
+type VolumeAttachmentCleaner struct {
+	VolumeAttachmentConfig
+	client kubernetes.Interface
+}
+
+func NewVolumeAttachmentCleaner(client kubernetes.Interface, cfg *VolumeAttachmentConfig) *VolumeAttachmentCleaner {
+	return &VolumeAttachmentCleaner{
+		VolumeAttachmentConfig: *cfg,
+		client:                 client,
+	}
+}
+
+func (vc *VolumeAttachmentCleaner) CleanUp(ctx context.Context, node *v1.Node, p provider.Provider) error {
+	attachments, err := vc.client.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("could not list volume attachments: %v", err)
+	}
+
+	// Carry on with the other attachments while one is still attached
+	var attached error
+
+	for _, va := range attachments.Items {
+		if va.Spec.NodeName != node.Name {
+			continue
+		}
+
+		log.Info("deleting volume attachment %s of node %s", va.Name, node.Name)
+		err := vc.client.StorageV1().VolumeAttachments().Delete(ctx, va.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete volume attachment %s: %v", va.Name, err)
+		}
+
+		if !vc.RemoveFinalizers || len(va.Finalizers) == 0 {
+			continue
+		}
+
+		err = vc.removeFinalizers(ctx, node, p, va)
+		if errors.Is(err, ErrVolumeAttached) {
+			attached = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return attached
+}
+
+// Remove an attachment's finalizers if the provider confirms its volume is
+// no longer attached to the node's instance
+func (vc *VolumeAttachmentCleaner) removeFinalizers(ctx context.Context, node *v1.Node, p provider.Provider, va storagev1.VolumeAttachment) error {
+	volumeID, err := vc.volumeID(ctx, va)
+	if err != nil {
+		return err
+	}
+	if volumeID == "" {
+		log.Debug("volume attachment %s is not for a CSI volume, leaving its finalizers", va.Name)
+		return nil
+	}
+
+	attached, err := provider.VolumeAttached(p, node.Spec.ProviderID, volumeID)
+	switch {
+	case errors.Is(err, provider.ErrUnsupported):
+		log.Debug("provider can't check volumes, leaving finalizers of volume attachment %s", va.Name)
+		return nil
+	case err != nil:
+		return fmt.Errorf("could not check volume %s of node %s: %v", volumeID, node.Name, err)
+	case attached:
+		return fmt.Errorf("%w: %s of node %s, leaving finalizers of volume attachment %s", ErrVolumeAttached, volumeID, node.Name, va.Name)
+	}
+
+	log.Info("volume %s is detached, removing finalizers of volume attachment %s", volumeID, va.Name)
+	_, err = vc.client.StorageV1().VolumeAttachments().Patch(
+		ctx,
+		va.Name,
+		types.MergePatchType,
+		[]byte(`{"metadata":{"finalizers":null}}`),
+		metav1.PatchOptions{},
+	)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not remove finalizers of volume attachment %s: %v", va.Name, err)
+	}
+
+	return nil
+}
+
+// The CSI volume handle of an attachment's volume, or empty if it isn't a
+// CSI volume
+func (vc *VolumeAttachmentCleaner) volumeID(ctx context.Context, va storagev1.VolumeAttachment) (string, error) {
+	source := va.Spec.Source
+
+	if source.InlineVolumeSpec != nil {
+		if source.InlineVolumeSpec.CSI == nil {
+			return "", nil
+		}
+		return source.InlineVolumeSpec.CSI.VolumeHandle, nil
+	}
+
+	if source.PersistentVolumeName == nil {
+		return "", nil
+	}
+
+	pv, err := vc.client.CoreV1().PersistentVolumes().Get(ctx, *source.PersistentVolumeName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not get persistent volume of volume attachment %s: %v", va.Name, err)
+	}
+	if pv.Spec.CSI == nil {
+		return "", nil
+	}
+
+	return pv.Spec.CSI.VolumeHandle, nil
+}
@@ -0,0 +1,303 @@
+package controller_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/controller"
+	"github.com/vixus0/skuttle/v2/internal/provider"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Volume attachment cleaner", func() {
+	var (
+		client       *fake.Clientset
+		cleaner      *controller.VolumeAttachmentCleaner
+		node         *v1.Node
+		fakeProvider *FakeVolumeProvider
+		ctx          context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		client = fake.NewSimpleClientset()
+
+		node = &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-missing"},
+			Spec:       v1.NodeSpec{ProviderID: "fake://node-missing"},
+		}
+
+		AddVolumeAttachment(client, "node-missing", "va-detached", "vol-detached")
+		AddVolumeAttachment(client, "node-missing", "va-attached", "vol-attached")
+		AddVolumeAttachment(client, "node-other", "va-other", "vol-other")
+
+		fakeProvider = &FakeVolumeProvider{
+			Attached: map[string]bool{
+				"vol-detached": false,
+				"vol-attached": true,
+			},
+		}
+
+		cleaner = controller.NewVolumeAttachmentCleaner(client, &controller.VolumeAttachmentConfig{})
+	})
+
+	It("Should delete the node's volume attachments", func() {
+		Expect(cleaner.CleanUp(ctx, node, fakeProvider)).To(Succeed())
+
+		Expect(VolumeAttachmentActions(client)).To(ConsistOf(
+			"delete va-detached",
+			"delete va-attached",
+		))
+	})
+
+	It("Should leave finalizers alone unless told to remove them", func() {
+		Expect(cleaner.CleanUp(ctx, node, fakeProvider)).To(Succeed())
+
+		Expect(fakeProvider.Checked).To(BeEmpty())
+	})
+
+	It("Should remove finalizers once the provider confirms the volume is detached", func() {
+		cleaner.RemoveFinalizers = true
+		fakeProvider.Attached["vol-attached"] = false
+		Expect(cleaner.CleanUp(ctx, node, fakeProvider)).To(Succeed())
+
+		Expect(fakeProvider.Checked).To(ConsistOf("vol-detached", "vol-attached"))
+		Expect(VolumeAttachmentActions(client)).To(ConsistOf(
+			"delete va-detached",
+			"patch va-detached",
+			"delete va-attached",
+			"patch va-attached",
+		))
+	})
+
+	It("Should ask to be retried while a volume is still attached", func() {
+		cleaner.RemoveFinalizers = true
+		err := cleaner.CleanUp(ctx, node, fakeProvider)
+
+		Expect(errors.Is(err, controller.ErrVolumeAttached)).To(BeTrue())
+		Expect(fakeProvider.Checked).To(ConsistOf("vol-detached", "vol-attached"))
+		Expect(VolumeAttachmentActions(client)).To(ConsistOf(
+			"delete va-detached",
+			"patch va-detached",
+			"delete va-attached",
+		))
+	})
+
+	It("Should leave finalizers alone if the provider can't check volumes", func() {
+		cleaner.RemoveFinalizers = true
+		Expect(cleaner.CleanUp(ctx, node, &FakeProvider{})).To(Succeed())
+
+		Expect(VolumeAttachmentActions(client)).To(ConsistOf(
+			"delete va-detached",
+			"delete va-attached",
+		))
+	})
+})
+
+var _ = Describe("Controller with a volume cleaner", func() {
+	var f *Fixture
+
+	BeforeEach(func() {
+		f = NewFixture(&FakeProvider{Nodes: map[string]bool{"node-missing": false}})
+		f.AddNodes(false, "node-missing")
+		AddVolumeAttachment(f.Client, "node-missing", "va-missing", "vol-missing")
+	})
+
+	AfterEach(func() {
+		f.Stop()
+	})
+
+	It("Should delete the node's volume attachments after the node", func() {
+		f.Config.VolumeCleaner = controller.NewVolumeAttachmentCleaner(f.Client, &controller.VolumeAttachmentConfig{})
+		f.Start()
+
+		Eventually(func() []string { return DeleteActions(f.Client) }).Should(ContainElement("delete volumeattachments va-missing"))
+		Expect(DeleteActions(f.Client)).To(Equal([]string{
+			"delete nodes node-missing",
+			"delete volumeattachments va-missing",
+		}))
+	})
+
+	It("Should retry cleaning up volumes after errors", func() {
+		f.Config.RetryBaseDelay = 10 * time.Millisecond
+		f.Config.VolumeCleaner = &FakeFlakyVolumeCleaner{
+			VolumeCleaner: controller.NewVolumeAttachmentCleaner(f.Client, &controller.VolumeAttachmentConfig{}),
+			Failures:      2,
+		}
+		f.Start()
+
+		Eventually(func() []string { return DeleteActions(f.Client) }).Should(ContainElement("delete volumeattachments va-missing"))
+	})
+	It("Should retry removing finalizers until the volume is detached", func() {
+		detaching := &FakeDetachingProvider{
+			FakeProvider: FakeProvider{Nodes: map[string]bool{"node-missing": false}},
+			AttachedFor:  2,
+		}
+		f.Provider = detaching
+		f.Config.RetryBaseDelay = 10 * time.Millisecond
+		f.Config.VolumeCleaner = controller.NewVolumeAttachmentCleaner(f.Client, &controller.VolumeAttachmentConfig{
+			RemoveFinalizers: true,
+		})
+		KeepFinalizedVolumeAttachments(f.Client)
+		f.Start()
+
+		Eventually(func() []string { return VolumeAttachmentActions(f.Client) }).Should(ContainElement("patch va-missing"))
+		Expect(detaching.Checks()).To(Equal(3))
+	})
+})
+
+// FakeFlakyVolumeCleaner fails a number of times before cleaning up
+type FakeFlakyVolumeCleaner struct {
+	controller.VolumeCleaner
+	Failures int
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (vc *FakeFlakyVolumeCleaner) CleanUp(ctx context.Context, node *v1.Node, p provider.Provider) error {
+	vc.mu.Lock()
+	vc.calls++
+	calls := vc.calls
+	vc.mu.Unlock()
+
+	if calls <= vc.Failures {
+		return fmt.Errorf("api server is having a bad day")
+	}
+	return vc.VolumeCleaner.CleanUp(ctx, node, p)
+}
+
+// FakeVolumeProvider says whether volumes are attached
+type FakeVolumeProvider struct {
+	FakeProvider
+	Attached map[string]bool
+	Checked  []string
+}
+
+func (p *FakeVolumeProvider) VolumeAttached(providerID, volumeID string) (bool, error) {
+	p.Checked = append(p.Checked, volumeID)
+	if attached, ok := p.Attached[volumeID]; ok {
+		return attached, nil
+	}
+	return true, fmt.Errorf("unknown volume: %v", volumeID)
+}
+
+// FakeDetachingProvider says volumes are attached for a number of checks,
+// and then detached
+type FakeDetachingProvider struct {
+	FakeProvider
+	AttachedFor int
+
+	mu     sync.Mutex
+	checks int
+}
+
+func (p *FakeDetachingProvider) VolumeAttached(providerID, volumeID string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checks++
+	return p.checks <= p.AttachedFor, nil
+}
+
+func (p *FakeDetachingProvider) Checks() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.checks
+}
+
+// Describe the changes made to volume attachments, in order
+func VolumeAttachmentActions(client *fake.Clientset) []string {
+	var actions []string
+	for _, action := range client.Actions() {
+		if action.GetResource().Resource != "volumeattachments" {
+			continue
+		}
+
+		switch action.GetVerb() {
+		case "patch":
+			actions = append(actions, fmt.Sprintf("patch %s", action.(clienttesting.PatchAction).GetName()))
+		case "delete":
+			actions = append(actions, fmt.Sprintf("delete %s", action.(clienttesting.DeleteAction).GetName()))
+		}
+	}
+	return actions
+}
+
+// Describe the deletions of nodes and volume attachments, in order
+func DeleteActions(client *fake.Clientset) []string {
+	var actions []string
+	for _, action := range client.Actions() {
+		if action, ok := action.(clienttesting.DeleteAction); ok {
+			actions = append(actions, fmt.Sprintf("delete %s %s", action.GetResource().Resource, action.GetName()))
+		}
+	}
+	return actions
+}
+
+// Make deleting volume attachments with finalizers only mark them as being
+// deleted, as the API server does
+func KeepFinalizedVolumeAttachments(client *fake.Clientset) {
+	volumeAttachments := storagev1.SchemeGroupVersion.WithResource("volumeattachments")
+	client.PrependReactor("delete", "volumeattachments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		obj, err := client.Tracker().Get(volumeAttachments, "", action.(clienttesting.DeleteAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+
+		va := obj.(*storagev1.VolumeAttachment)
+		if len(va.Finalizers) == 0 {
+			return false, nil, nil
+		}
+
+		now := metav1.Now()
+		va.DeletionTimestamp = &now
+		return true, nil, client.Tracker().Update(volumeAttachments, va, "")
+	})
+}
+
+// Add an attachment of a CSI persistent volume to a node
+func AddVolumeAttachment(client *fake.Clientset, nodeName, name, volumeID string) {
+	pvName := fmt.Sprintf("pv-%s", name)
+
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: pvName},
+		Spec: v1.PersistentVolumeSpec{
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				CSI: &v1.CSIPersistentVolumeSource{
+					Driver:       "fake.csi",
+					VolumeHandle: volumeID,
+				},
+			},
+		},
+	}
+	if _, err := client.CoreV1().PersistentVolumes().Create(context.TODO(), pv, metav1.CreateOptions{}); err != nil {
+		Fail(fmt.Sprintf("error adding persistent volume: %v", err))
+	}
+
+	va := &storagev1.VolumeAttachment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Finalizers: []string{"external-attacher/fake-csi"},
+		},
+		Spec: storagev1.VolumeAttachmentSpec{
+			Attacher: "fake.csi",
+			NodeName: nodeName,
+			Source:   storagev1.VolumeAttachmentSource{PersistentVolumeName: &pvName},
+		},
+	}
+	if _, err := client.StorageV1().VolumeAttachments().Create(context.TODO(), va, metav1.CreateOptions{}); err != nil {
+		Fail(fmt.Sprintf("error adding volume attachment: %v", err))
+	}
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 )
 
@@ -19,23 +25,171 @@ var (
 	log *logging.Logger = logging.NewLogger("provider/aws")
 )
 
+// StateMissing is the state of instances EC2 doesn't know about
+const StateMissing = "missing"
+
+// MaxBatchSize is the most instance IDs described in one call
+const MaxBatchSize = 1000
+
+// KnownStates are the EC2 instance states, and missing
+var KnownStates = []string{
+	string(ec2types.InstanceStateNamePending),
+	string(ec2types.InstanceStateNameRunning),
+	string(ec2types.InstanceStateNameShuttingDown),
+	string(ec2types.InstanceStateNameTerminated),
+	string(ec2types.InstanceStateNameStopping),
+	string(ec2types.InstanceStateNameStopped),
+	StateMissing,
+}
+
+// DefaultGoneStates are the instance states which count as gone unless
+// configured otherwise, so stopped instances are kept
+var DefaultGoneStates = []string{
+	string(ec2types.InstanceStateNameShuttingDown),
+	string(ec2types.InstanceStateNameTerminated),
+	StateMissing,
+}
+
+// Matches the region at the start of an availability zone, including local
+// and wavelength zones like us-west-2-lax-1a
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-gov)?-[a-z]+-[0-9]+`)
+
+// EC2Client is the part of the EC2 API used to look up instances and
+// their volumes
+type EC2Client interface {
+	ec2.DescribeInstancesAPIClient
+	ec2.DescribeVolumesAPIClient
+}
+
+// NewClientFunc creates an EC2 client for a region, assuming a role if
+// roleARN isn't empty
+type NewClientFunc func(region, roleARN string) EC2Client
+
+type Config struct {
+	// Instance states which count as gone, defaults to DefaultGoneStates
+	GoneStates []string
+	// Roles to assume to look for instances in other accounts
+	RoleARNs []string
+	// Overrides the default region
+	Region string
+	// URL of an EC2 compatible API to use instead of AWS, such as an emulator
+	Endpoint string
+	// Static credentials to use instead of the default credential chain
+	AccessKeyID     string
+	SecretAccessKey string
+	// Don't check IAM permissions with a dry run on startup
+	SkipDryRun bool
+}
+
 type Provider struct {
-	Client ec2.DescribeInstancesAPIClient
+	// Client for the default region and credentials, used for every
+	// lookup if NewClient is nil
+	Client EC2Client
+	// Creates clients for other regions and roles
+	NewClient NewClientFunc
+	// Region used when a provider ID has no availability zone
+	Region string
+	// Roles to assume when an instance isn't found with the default
+	// credentials, searched in order
+	RoleARNs   []string
+	GoneStates []string
+
+	mu      sync.Mutex
+	clients map[string]EC2Client
 }
 
-func NewProvider(ctx context.Context) (*Provider, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+func NewProvider(ctx context.Context, cfg *Config) (*Provider, error) {
+	goneStates := cfg.GoneStates
+	if len(goneStates) == 0 {
+		goneStates = DefaultGoneStates
+	}
+
+	if err := skprovider.ValidateGoneStates(goneStates, KnownStates); err != nil {
+		return nil, fmt.Errorf("invalid gone states, %v", err)
+	}
+
+	var opts []func(*config.LoadOptions) error
+
+	if cfg.Region != "" {
+		opts = append(opts, config.WithRegion(cfg.Region))
+	}
+
+	if cfg.Endpoint != "" {
+		log.Info("using endpoint %s", cfg.Endpoint)
+		opts = append(opts, config.WithEndpointResolver(aws.EndpointResolverFunc(
+			func(service, region string) (aws.Endpoint, error) {
+				// Leave other services like STS, for assuming roles, to
+				// the default endpoints
+				if service != ec2.ServiceID {
+					return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+				}
+				return aws.Endpoint{
+					URL:           cfg.Endpoint,
+					SigningRegion: region,
+				}, nil
+			},
+		)))
+	}
+
+	if cfg.AccessKeyID != "" || cfg.SecretAccessKey != "" {
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load configuration, %v", err)
 	}
 
-	ec2client := ec2.NewFromConfig(cfg)
+	newClient := func(region, roleARN string) EC2Client {
+		regionCfg := awsCfg.Copy()
+		if region != "" {
+			regionCfg.Region = region
+		}
+
+		if roleARN != "" {
+			stsClient := sts.NewFromConfig(regionCfg)
+			regionCfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, roleARN))
+		}
+
+		return ec2.NewFromConfig(regionCfg)
+	}
+
+	provider := &Provider{
+		Client:     ec2.NewFromConfig(awsCfg),
+		NewClient:  newClient,
+		Region:     awsCfg.Region,
+		RoleARNs:   cfg.RoleARNs,
+		GoneStates: goneStates,
+	}
+
+	if cfg.SkipDryRun {
+		log.Warn("skipping ec2 dry run")
+	} else {
+		// Do a dry run to check we have the right IAM permissions
+		if err := dryRun(provider.Client); err != nil {
+			return nil, err
+		}
+
+		for _, roleARN := range cfg.RoleARNs {
+			log.Info("checking role %s", roleARN)
+			if err := dryRun(provider.client(awsCfg.Region, roleARN)); err != nil {
+				return nil, fmt.Errorf("failed to use role %s, %v", roleARN, err)
+			}
+		}
+	}
+
+	log.Info("instances count as gone when %s", strings.Join(goneStates, ", "))
+
+	return provider, nil
+}
 
-	// Do a dry run to check we have the right IAM permissions
+func dryRun(client EC2Client) error {
 	var apiErr smithy.APIError
 
 	log.Info("performing ec2 dry run")
-	_, err = ec2client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+	_, err := client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
 		DryRun: aws.Bool(true),
 	})
 
@@ -46,40 +200,245 @@ func NewProvider(ctx context.Context) (*Provider, error) {
 				log.Info("dry run successful")
 			case "UnauthorizedOperation":
 				log.Info("dry run failed")
-				return nil, err
+				return err
+			default:
+				log.Warn("unexpected dry run result - code: %v, message: %v", apiErr.ErrorCode(), apiErr.ErrorMessage())
 			}
 		} else {
+			return err
+		}
+	} else {
+		// Emulators don't always implement dry runs
+		log.Warn("dry run was not supported, assuming permissions are fine")
+	}
+
+	return nil
+}
+
+// RegionFromZone returns the region of an availability zone, or an empty
+// string if it doesn't look like one
+func RegionFromZone(zone string) string {
+	return regionPattern.FindString(zone)
+}
+
+// client returns a cached client for a region and role
+func (provider *Provider) client(region, roleARN string) EC2Client {
+	if provider.NewClient == nil {
+		return provider.Client
+	}
+
+	if region == "" {
+		region = provider.Region
+	}
+
+	if region == provider.Region && roleARN == "" && provider.Client != nil {
+		return provider.Client
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	key := region + "/" + roleARN
+	if client, ok := provider.clients[key]; ok {
+		return client
+	}
+
+	if provider.clients == nil {
+		provider.clients = map[string]EC2Client{}
+	}
+
+	log.Debug("creating client for region %s, role %s", region, roleARN)
+	client := provider.NewClient(region, roleARN)
+	provider.clients[key] = client
+
+	return client
+}
+
+// InstanceVerdict reports the instance's lifecycle state along with whether
+// it counts as gone
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	state, err := provider.InstanceState(providerID)
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	return skprovider.GoneVerdict(state, provider.GoneStates, DefaultGoneStates), nil
+}
+
+// InstanceVerdicts gives verdicts on several instances with as few calls as
+// possible
+func (provider *Provider) InstanceVerdicts(providerIDs []string) (map[string]skprovider.Verdict, error) {
+	states, err := provider.InstanceStates(providerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	verdicts := map[string]skprovider.Verdict{}
+	for providerID, state := range states {
+		verdicts[providerID] = skprovider.GoneVerdict(state, provider.GoneStates, DefaultGoneStates)
+	}
+
+	return verdicts, nil
+}
+
+// VolumeAttached checks whether an EBS volume is still attached to an
+// instance, counting volumes which are still detaching as attached. Volumes
+// which no longer exist aren't attached to anything.
+func (provider *Provider) VolumeAttached(providerID, volumeID string) (bool, error) {
+	instanceID, region, err := parseProviderID(providerID)
+	if err != nil {
+		return true, err
+	}
+
+	roleARNs := append([]string{""}, provider.RoleARNs...)
+	for _, roleARN := range roleARNs {
+		out, err := provider.client(region, roleARN).DescribeVolumes(context.TODO(), &ec2.DescribeVolumesInput{
+			VolumeIds: []string{volumeID},
+		})
+
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidVolume.NotFound" {
+			// look for the volume with the next role
+			continue
+		}
+		if err != nil {
+			return true, err
+		}
+
+		for _, volume := range out.Volumes {
+			for _, attachment := range volume.Attachments {
+				if aws.ToString(attachment.InstanceId) == instanceID && attachment.State != ec2types.VolumeAttachmentStateDetached {
+					log.Debug("volume %s is %s to instance %s", volumeID, attachment.State, instanceID)
+					return true, nil
+				}
+			}
+		}
+
+		return false, nil
+	}
+
+	log.Info("no volume found for volume ID %s", volumeID)
+	return false, nil
+}
+
+// InstanceState returns the lifecycle state of an instance, such as running
+// or stopped, or StateMissing if there is no such instance
+func (provider *Provider) InstanceState(providerID string) (string, error) {
+	states, err := provider.InstanceStates([]string{providerID})
+	if err != nil {
+		return "", err
+	}
+
+	return states[providerID], nil
+}
+
+// InstanceStates returns the lifecycle state of several instances, keyed by
+// provider ID, describing up to MaxBatchSize instances per call. Instances
+// are looked up in the region of their availability zone, first with the
+// default credentials and then with each role.
+func (provider *Provider) InstanceStates(providerIDs []string) (map[string]string, error) {
+	// provider IDs for each instance ID, and instance IDs in each region
+	ids := map[string][]string{}
+	regions := map[string][]string{}
+
+	for _, providerID := range providerIDs {
+		instanceID, region, err := parseProviderID(providerID)
+		if err != nil {
 			return nil, err
 		}
+
+		if _, ok := ids[instanceID]; !ok {
+			regions[region] = append(regions[region], instanceID)
+		}
+		ids[instanceID] = append(ids[instanceID], providerID)
+	}
+
+	instanceStates := map[string]string{}
+
+	for region, instanceIDs := range regions {
+		roleARNs := append([]string{""}, provider.RoleARNs...)
+
+		for _, roleARN := range roleARNs {
+			if len(instanceIDs) == 0 {
+				break
+			}
+
+			found := map[string]string{}
+			if err := provider.describeAll(provider.client(region, roleARN), instanceIDs, found); err != nil {
+				return nil, err
+			}
+
+			// look for missing instances with the next role
+			var missing []string
+			for _, instanceID := range instanceIDs {
+				if found[instanceID] == StateMissing {
+					missing = append(missing, instanceID)
+				} else {
+					instanceStates[instanceID] = found[instanceID]
+				}
+			}
+			instanceIDs = missing
+		}
+
+		for _, instanceID := range instanceIDs {
+			log.Info("no instance found for instance ID %s", instanceID)
+			instanceStates[instanceID] = StateMissing
+		}
+	}
+
+	states := map[string]string{}
+	for instanceID, state := range instanceStates {
+		for _, providerID := range ids[instanceID] {
+			states[providerID] = state
+		}
 	}
 
-	return &Provider{
-		Client: ec2client,
-	}, nil
+	return states, nil
 }
 
-func (provider *Provider) InstanceExists(providerID string) (bool, error) {
+// parseProviderID returns the instance ID of a provider ID, and the region
+// of its availability zone if it has one
+func parseProviderID(providerID string) (string, string, error) {
 	// should have been checked already, being defensive
 	if !strings.HasPrefix(providerID, "aws:///") {
-		return false, fmt.Errorf("providerID %s does not start with aws:///", providerID)
+		return "", "", fmt.Errorf("providerID %s does not start with aws:///", providerID)
 	}
 
-	// assume EC2 instance ID is the last path segment of a provider ID
-	parts := strings.Split(providerID, "/")
+	// assume EC2 instance ID is the last path segment of a provider ID,
+	// after the availability zone if there is one
+	parts := strings.Split(strings.TrimPrefix(providerID, "aws:///"), "/")
 	instanceID := parts[len(parts)-1]
 
-	out, err := provider.Client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
-		InstanceIds: []string{
-			instanceID,
-		},
-		Filters: []ec2types.Filter{
-			{
-				Name: aws.String("instance-state-name"),
-				Values: []string{
-					"running",
-				},
-			},
-		},
+	region := ""
+	if len(parts) > 1 {
+		region = RegionFromZone(parts[len(parts)-2])
+	}
+
+	return instanceID, region, nil
+}
+
+// describeAll fills in the states of instances in batches of MaxBatchSize
+func (provider *Provider) describeAll(client EC2Client, instanceIDs []string, states map[string]string) error {
+	for start := 0; start < len(instanceIDs); start += MaxBatchSize {
+		end := start + MaxBatchSize
+		if end > len(instanceIDs) {
+			end = len(instanceIDs)
+		}
+
+		if err := provider.describe(client, instanceIDs[start:end], states); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// describe fills in the states of a batch of instances. EC2 fails the whole
+// call if any instance ID is unknown, so batches are split in half until the
+// missing instances are found.
+func (provider *Provider) describe(client EC2Client, instanceIDs []string, states map[string]string) error {
+	out, err := client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
+		InstanceIds: instanceIDs,
 	})
 
 	// Deal with API errors
@@ -89,20 +448,39 @@ func (provider *Provider) InstanceExists(providerID string) (bool, error) {
 		if errors.As(err, &apiErr) {
 			switch apiErr.ErrorCode() {
 			case "InvalidInstanceID.NotFound":
-				log.Info("no instance found for instance ID %s", instanceID)
-				return false, nil
+				if len(instanceIDs) == 1 {
+					states[instanceIDs[0]] = StateMissing
+					return nil
+				}
+
+				half := len(instanceIDs) / 2
+				if err := provider.describe(client, instanceIDs[:half], states); err != nil {
+					return err
+				}
+				return provider.describe(client, instanceIDs[half:], states)
 			default:
 				log.Error("aws API error - code: %v, message: %v", apiErr.ErrorCode(), apiErr.ErrorMessage())
 			}
 		}
 
-		return false, err
+		return err
 	}
 
-	if len(out.Reservations) == 0 {
-		log.Info("no reservations for instance ID %s", instanceID)
-		return false, nil
+	for _, reservation := range out.Reservations {
+		for _, instance := range reservation.Instances {
+			if instance.State != nil {
+				instanceID := aws.ToString(instance.InstanceId)
+				log.Debug("instance %s is %s", instanceID, instance.State.Name)
+				states[instanceID] = string(instance.State.Name)
+			}
+		}
+	}
+
+	for _, instanceID := range instanceIDs {
+		if _, ok := states[instanceID]; !ok {
+			states[instanceID] = StateMissing
+		}
 	}
 
-	return true, nil
+	return nil
 }
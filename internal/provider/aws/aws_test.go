@@ -7,6 +7,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
 	"github.com/vixus0/skuttle/v2/internal/provider/aws"
 
 	awssdk "github.com/aws/aws-sdk-go-v2/aws"
@@ -17,6 +18,7 @@ import (
 
 const (
 	runningID    = "i-0123abcdef"
+	stoppedID    = "i-0badc0ffee"
 	terminatedID = "i-deadbeef69"
 	missingID    = "i-aabbccdd00"
 	errorID      = "i-xxxxxxxx"
@@ -25,37 +27,57 @@ const (
 var _ = Describe("AWS Provider", func() {
 	var (
 		provider *aws.Provider
+		client   *MockEC2Client
 	)
 
 	BeforeEach(func() {
-		provider = &aws.Provider{
-			Client: &MockEC2Client{
-				instances: []*MockInstance{
-					{
-						ID:    runningID,
-						State: "running",
-					},
-					{
-						ID:    terminatedID,
-						State: "terminated",
-					},
+		client = &MockEC2Client{
+			instances: []*MockInstance{
+				{
+					ID:    runningID,
+					State: "running",
+				},
+				{
+					ID:    stoppedID,
+					State: "stopped",
 				},
+				{
+					ID:    terminatedID,
+					State: "terminated",
+				},
+			},
+			volumes: []*MockVolume{
+				{ID: "vol-attached", InstanceID: runningID, State: "attached"},
+				{ID: "vol-detaching", InstanceID: stoppedID, State: "detaching"},
+				{ID: "vol-detached", InstanceID: stoppedID, State: "detached"},
+				{ID: "vol-elsewhere", InstanceID: stoppedID, State: "attached"},
 			},
 		}
+		provider = &aws.Provider{
+			Client: client,
+		}
 	})
 
 	Describe("Checking if an EC2 instance exists", func() {
 		It("should be true for running instances", func() {
 			providerID := fmt.Sprintf("aws:///region/%s", runningID)
-			exists, err := provider.InstanceExists(providerID)
+			exists, err := skprovider.InstanceExists(provider, providerID)
 
 			Expect(err).To(BeNil())
 			Expect(exists).To(BeTrue())
 		})
 
-		It("should not be true for stopped or terminated instances", func() {
+		It("should be true for stopped instances", func() {
+			providerID := fmt.Sprintf("aws:///region/%s", stoppedID)
+			exists, err := skprovider.InstanceExists(provider, providerID)
+
+			Expect(err).To(BeNil())
+			Expect(exists).To(BeTrue())
+		})
+
+		It("should not be true for terminated instances", func() {
 			providerID := fmt.Sprintf("aws:///region/%s", terminatedID)
-			exists, err := provider.InstanceExists(providerID)
+			exists, err := skprovider.InstanceExists(provider, providerID)
 
 			Expect(err).To(BeNil())
 			Expect(exists).To(BeFalse())
@@ -63,7 +85,7 @@ var _ = Describe("AWS Provider", func() {
 
 		It("should not be true when the instance was not found", func() {
 			providerID := fmt.Sprintf("aws:///region/%s", missingID)
-			exists, err := provider.InstanceExists(providerID)
+			exists, err := skprovider.InstanceExists(provider, providerID)
 
 			Expect(err).To(BeNil())
 			Expect(exists).To(BeFalse())
@@ -71,12 +93,221 @@ var _ = Describe("AWS Provider", func() {
 
 		It("should propagate errors", func() {
 			providerID := fmt.Sprintf("aws:///region/%s", errorID)
-			exists, err := provider.InstanceExists(providerID)
+			exists, err := skprovider.InstanceExists(provider, providerID)
 
 			Expect(err).To(HaveOccurred())
 			Expect(exists).To(BeFalse())
 		})
 	})
+
+	Describe("Checking several EC2 instances at once", func() {
+		It("should check them in a single call", func() {
+			verdicts, err := provider.InstanceVerdicts([]string{
+				fmt.Sprintf("aws:///region-a/%s", runningID),
+				fmt.Sprintf("aws:///region-b/%s", stoppedID),
+				fmt.Sprintf("aws:///region-c/%s", terminatedID),
+			})
+
+			Expect(err).To(BeNil())
+			Expect(States(verdicts)).To(Equal(map[string]skprovider.State{
+				fmt.Sprintf("aws:///region-a/%s", runningID):    skprovider.Exists,
+				fmt.Sprintf("aws:///region-b/%s", stoppedID):    skprovider.Exists,
+				fmt.Sprintf("aws:///region-c/%s", terminatedID): skprovider.Gone,
+			}))
+			Expect(client.calls).To(Equal(1))
+		})
+
+		It("should find missing instances among the others", func() {
+			verdicts, err := provider.InstanceVerdicts([]string{
+				fmt.Sprintf("aws:///region/%s", runningID),
+				fmt.Sprintf("aws:///region/%s", missingID),
+				fmt.Sprintf("aws:///region/%s", stoppedID),
+			})
+
+			Expect(err).To(BeNil())
+			Expect(States(verdicts)).To(Equal(map[string]skprovider.State{
+				fmt.Sprintf("aws:///region/%s", runningID): skprovider.Exists,
+				fmt.Sprintf("aws:///region/%s", missingID): skprovider.Gone,
+				fmt.Sprintf("aws:///region/%s", stoppedID): skprovider.Exists,
+			}))
+		})
+
+		It("should split large batches", func() {
+			var providerIDs []string
+			for i := 0; i < 2500; i++ {
+				id := fmt.Sprintf("i-%08d", i)
+				client.instances = append(client.instances, &MockInstance{ID: id, State: "running"})
+				providerIDs = append(providerIDs, fmt.Sprintf("aws:///region/%s", id))
+			}
+
+			verdicts, err := provider.InstanceVerdicts(providerIDs)
+
+			Expect(err).To(BeNil())
+			Expect(verdicts).To(HaveLen(2500))
+			Expect(client.calls).To(Equal(3))
+		})
+
+		It("should propagate errors", func() {
+			_, err := provider.InstanceVerdicts([]string{
+				fmt.Sprintf("aws:///region/%s", runningID),
+				fmt.Sprintf("aws:///region/%s", errorID),
+			})
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Finding the region of an availability zone", func() {
+		It("should strip the zone suffix", func() {
+			Expect(aws.RegionFromZone("us-east-1a")).To(Equal("us-east-1"))
+			Expect(aws.RegionFromZone("eu-west-2c")).To(Equal("eu-west-2"))
+			Expect(aws.RegionFromZone("us-gov-west-1b")).To(Equal("us-gov-west-1"))
+			Expect(aws.RegionFromZone("us-west-2-lax-1a")).To(Equal("us-west-2"))
+			Expect(aws.RegionFromZone("ap-southeast-1")).To(Equal("ap-southeast-1"))
+		})
+
+		It("should be empty for anything else", func() {
+			Expect(aws.RegionFromZone("region")).To(Equal(""))
+			Expect(aws.RegionFromZone("")).To(Equal(""))
+		})
+	})
+
+	Describe("Checking instances across regions and accounts", func() {
+		var (
+			clients map[string]*MockEC2Client
+		)
+
+		BeforeEach(func() {
+			clients = map[string]*MockEC2Client{
+				"us-east-1/": client,
+				"eu-west-1/": {
+					instances: []*MockInstance{{ID: "i-eu", State: "running"}},
+				},
+				"us-east-1/arn:aws:iam::222222222222:role/skuttle": {
+					instances: []*MockInstance{{ID: "i-other", State: "running"}},
+				},
+				"eu-west-1/arn:aws:iam::222222222222:role/skuttle": {},
+			}
+
+			provider = &aws.Provider{
+				Client: client,
+				NewClient: func(region, roleARN string) aws.EC2Client {
+					return clients[region+"/"+roleARN]
+				},
+				Region:   "us-east-1",
+				RoleARNs: []string{"arn:aws:iam::222222222222:role/skuttle"},
+			}
+		})
+
+		It("should look up instances in the region of their zone", func() {
+			verdicts, err := provider.InstanceVerdicts([]string{
+				fmt.Sprintf("aws:///us-east-1a/%s", runningID),
+				"aws:///eu-west-1b/i-eu",
+			})
+
+			Expect(err).To(BeNil())
+			Expect(States(verdicts)).To(Equal(map[string]skprovider.State{
+				fmt.Sprintf("aws:///us-east-1a/%s", runningID): skprovider.Exists,
+				"aws:///eu-west-1b/i-eu":                       skprovider.Exists,
+			}))
+			Expect(clients["us-east-1/"].calls).To(Equal(1))
+			Expect(clients["eu-west-1/"].calls).To(Equal(1))
+		})
+
+		It("should use the default region without a zone", func() {
+			Expect(skprovider.InstanceExists(provider, fmt.Sprintf("aws:///%s", runningID))).To(BeTrue())
+			Expect(clients["us-east-1/"].calls).To(Equal(1))
+		})
+
+		It("should look for missing instances with each role", func() {
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-other")).To(BeTrue())
+			Expect(clients["us-east-1/arn:aws:iam::222222222222:role/skuttle"].calls).To(Equal(1))
+		})
+
+		It("should only be missing if no account has the instance", func() {
+			Expect(skprovider.InstanceExists(provider, "aws:///eu-west-1a/i-nowhere")).To(BeFalse())
+			Expect(clients["eu-west-1/"].calls).To(Equal(1))
+			Expect(clients["eu-west-1/arn:aws:iam::222222222222:role/skuttle"].calls).To(Equal(1))
+		})
+
+		It("should not ask other accounts about instances already found", func() {
+			Expect(skprovider.InstanceExists(provider, fmt.Sprintf("aws:///us-east-1a/%s", terminatedID))).To(BeFalse())
+			Expect(clients["us-east-1/arn:aws:iam::222222222222:role/skuttle"].calls).To(Equal(0))
+		})
+	})
+
+	Describe("Getting the state of an EC2 instance", func() {
+		It("should return the lifecycle state", func() {
+			Expect(provider.InstanceState(fmt.Sprintf("aws:///region/%s", runningID))).To(Equal("running"))
+			Expect(provider.InstanceState(fmt.Sprintf("aws:///region/%s", stoppedID))).To(Equal("stopped"))
+			Expect(provider.InstanceState(fmt.Sprintf("aws:///region/%s", terminatedID))).To(Equal("terminated"))
+		})
+
+		It("should be missing when the instance was not found", func() {
+			Expect(provider.InstanceState(fmt.Sprintf("aws:///region/%s", missingID))).To(Equal(aws.StateMissing))
+		})
+	})
+
+	Describe("Getting a verdict on an EC2 instance", func() {
+		It("should report stopped instances as existing with their state", func() {
+			verdict, err := provider.InstanceVerdict(fmt.Sprintf("aws:///region/%s", stoppedID))
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+			Expect(verdict.ProviderState).To(Equal("stopped"))
+		})
+
+		It("should report terminated and missing instances as gone", func() {
+			verdicts, err := provider.InstanceVerdicts([]string{
+				fmt.Sprintf("aws:///region/%s", terminatedID),
+				fmt.Sprintf("aws:///region/%s", missingID),
+			})
+
+			Expect(err).To(BeNil())
+			Expect(verdicts[fmt.Sprintf("aws:///region/%s", terminatedID)].State).To(Equal(skprovider.Gone))
+			Expect(verdicts[fmt.Sprintf("aws:///region/%s", missingID)].ProviderState).To(Equal(aws.StateMissing))
+		})
+	})
+
+	Describe("Checking whether a volume is attached", func() {
+		It("should be attached while attached to the instance or detaching from it", func() {
+			Expect(provider.VolumeAttached(fmt.Sprintf("aws:///region/%s", runningID), "vol-attached")).To(BeTrue())
+			Expect(provider.VolumeAttached(fmt.Sprintf("aws:///region/%s", stoppedID), "vol-detaching")).To(BeTrue())
+		})
+
+		It("should be detached once detached from the instance", func() {
+			Expect(provider.VolumeAttached(fmt.Sprintf("aws:///region/%s", stoppedID), "vol-detached")).To(BeFalse())
+			Expect(provider.VolumeAttached(fmt.Sprintf("aws:///region/%s", runningID), "vol-elsewhere")).To(BeFalse())
+		})
+
+		It("should be detached when the volume doesn't exist", func() {
+			Expect(provider.VolumeAttached(fmt.Sprintf("aws:///region/%s", terminatedID), "vol-missing")).To(BeFalse())
+		})
+
+		It("should be attached if the volume can't be checked", func() {
+			attached, err := provider.VolumeAttached(fmt.Sprintf("aws:///region/%s", runningID), "vol-error")
+
+			Expect(err).To(HaveOccurred())
+			Expect(attached).To(BeTrue())
+		})
+	})
+
+	Describe("Configuring which states count as gone", func() {
+		It("should follow the configured states", func() {
+			provider.GoneStates = []string{"stopped", "terminated"}
+
+			Expect(skprovider.InstanceExists(provider, fmt.Sprintf("aws:///region/%s", runningID))).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, fmt.Sprintf("aws:///region/%s", stoppedID))).To(BeFalse())
+			Expect(skprovider.InstanceExists(provider, fmt.Sprintf("aws:///region/%s", terminatedID))).To(BeFalse())
+			Expect(skprovider.InstanceExists(provider, fmt.Sprintf("aws:///region/%s", missingID))).To(BeTrue())
+		})
+
+		It("should reject unknown states", func() {
+			_, err := aws.NewProvider(context.TODO(), &aws.Config{GoneStates: []string{"stopped", "exploded"}})
+
+			Expect(err).To(MatchError(ContainSubstring(`unknown state "exploded"`)))
+		})
+	})
 })
 
 type MockInstance struct {
@@ -84,46 +315,116 @@ type MockInstance struct {
 	State string
 }
 
+type MockVolume struct {
+	ID         string
+	InstanceID string
+	State      string
+}
+
 type MockEC2Client struct {
-	ec2.DescribeInstancesAPIClient
+	aws.EC2Client
 	instances []*MockInstance
+	volumes   []*MockVolume
+	calls     int
 }
 
 func (c *MockEC2Client) DescribeInstances(ctx context.Context, input *ec2.DescribeInstancesInput, fn ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
-	var reservations []ec2types.Reservation
+	var instances []ec2types.Instance
 
-	// We only ever search for one instance ID at a time
-	id := input.InstanceIds[0]
+	c.calls++
 
-	switch id {
-	case missingID:
+	if len(input.InstanceIds) > aws.MaxBatchSize {
 		return nil, &smithy.GenericAPIError{
-			Code:    "InvalidInstanceID.NotFound",
-			Message: "Mock instance not found error",
-			Fault:   smithy.FaultServer,
-		}
-	case errorID:
-		return nil, &smithy.GenericAPIError{
-			Code:    "SomeError",
-			Message: "Mock error",
-			Fault:   smithy.FaultUnknown,
+			Code:    "InvalidParameterValue",
+			Message: "Mock too many instance IDs error",
+			Fault:   smithy.FaultClient,
 		}
 	}
 
-	// Assuming one reservation for a matching instance ID
-	for _, instance := range c.instances {
-		if instance.ID == id && instance.State == "running" {
-			reservations = append(reservations, ec2types.Reservation{
-				Instances: []ec2types.Instance{
-					{
-						InstanceId: awssdk.String(id),
+	// Like EC2, fail the whole call if any instance is unknown
+	for _, id := range input.InstanceIds {
+		switch id {
+		case errorID:
+			return nil, &smithy.GenericAPIError{
+				Code:    "SomeError",
+				Message: "Mock error",
+				Fault:   smithy.FaultUnknown,
+			}
+		case missingID:
+			return nil, &smithy.GenericAPIError{
+				Code:    "InvalidInstanceID.NotFound",
+				Message: "Mock instance not found error",
+				Fault:   smithy.FaultServer,
+			}
+		}
+
+		for _, instance := range c.instances {
+			if instance.ID == id {
+				instances = append(instances, ec2types.Instance{
+					InstanceId: awssdk.String(id),
+					State: &ec2types.InstanceState{
+						Name: ec2types.InstanceStateName(instance.State),
 					},
-				},
-			})
+				})
+			}
 		}
 	}
 
+	// Assuming one reservation for all matching instances
 	return &ec2.DescribeInstancesOutput{
-		Reservations: reservations,
+		Reservations: []ec2types.Reservation{
+			{
+				Instances: instances,
+			},
+		},
 	}, nil
 }
+
+func (c *MockEC2Client) DescribeVolumes(ctx context.Context, input *ec2.DescribeVolumesInput, fn ...func(*ec2.Options)) (*ec2.DescribeVolumesOutput, error) {
+	var volumes []ec2types.Volume
+
+	for _, id := range input.VolumeIds {
+		if id == "vol-error" {
+			return nil, &smithy.GenericAPIError{
+				Code:    "SomeError",
+				Message: "Mock error",
+				Fault:   smithy.FaultUnknown,
+			}
+		}
+
+		found := false
+		for _, volume := range c.volumes {
+			if volume.ID == id {
+				found = true
+				volumes = append(volumes, ec2types.Volume{
+					VolumeId: awssdk.String(id),
+					Attachments: []ec2types.VolumeAttachment{
+						{
+							InstanceId: awssdk.String(volume.InstanceID),
+							State:      ec2types.VolumeAttachmentState(volume.State),
+						},
+					},
+				})
+			}
+		}
+
+		if !found {
+			return nil, &smithy.GenericAPIError{
+				Code:    "InvalidVolume.NotFound",
+				Message: "Mock volume not found error",
+				Fault:   smithy.FaultClient,
+			}
+		}
+	}
+
+	return &ec2.DescribeVolumesOutput{Volumes: volumes}, nil
+}
+
+// States picks the state out of each verdict
+func States(verdicts map[string]skprovider.Verdict) map[string]skprovider.State {
+	states := map[string]skprovider.State{}
+	for id, verdict := range verdicts {
+		states[id] = verdict.State
+	}
+	return states
+}
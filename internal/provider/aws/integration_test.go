@@ -0,0 +1,181 @@
+package aws_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/aws"
+)
+
+var _ = Describe("AWS Provider against an EC2 endpoint", func() {
+	var (
+		fakeEC2 *FakeEC2
+		server  *httptest.Server
+		cfg     *aws.Config
+	)
+
+	BeforeEach(func() {
+		fakeEC2 = &FakeEC2{
+			DryRunCode: "DryRunOperation",
+			Instances: map[string]string{
+				runningID:    "running",
+				stoppedID:    "stopped",
+				terminatedID: "terminated",
+			},
+		}
+		server = httptest.NewServer(fakeEC2)
+
+		cfg = &aws.Config{
+			Region:          "us-east-1",
+			Endpoint:        server.URL,
+			AccessKeyID:     "AKIDSKUTTLE",
+			SecretAccessKey: "secret",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should check instances at the endpoint", func() {
+		provider, err := aws.NewProvider(context.Background(), cfg)
+		Expect(err).To(BeNil())
+
+		verdicts, err := provider.InstanceVerdicts([]string{
+			fmt.Sprintf("aws:///us-east-1a/%s", runningID),
+			fmt.Sprintf("aws:///us-east-1a/%s", stoppedID),
+			fmt.Sprintf("aws:///us-east-1b/%s", terminatedID),
+			fmt.Sprintf("aws:///us-east-1b/%s", missingID),
+		})
+
+		Expect(err).To(BeNil())
+		Expect(States(verdicts)).To(Equal(map[string]skprovider.State{
+			fmt.Sprintf("aws:///us-east-1a/%s", runningID):    skprovider.Exists,
+			fmt.Sprintf("aws:///us-east-1a/%s", stoppedID):    skprovider.Exists,
+			fmt.Sprintf("aws:///us-east-1b/%s", terminatedID): skprovider.Gone,
+			fmt.Sprintf("aws:///us-east-1b/%s", missingID):    skprovider.Gone,
+		}))
+	})
+
+	It("should sign requests with the static credentials", func() {
+		provider, err := aws.NewProvider(context.Background(), cfg)
+		Expect(err).To(BeNil())
+
+		_, err = skprovider.InstanceExists(provider, fmt.Sprintf("aws:///us-east-1a/%s", runningID))
+		Expect(err).To(BeNil())
+
+		for _, auth := range fakeEC2.Authorizations() {
+			Expect(auth).To(ContainSubstring("Credential=AKIDSKUTTLE/"))
+			Expect(auth).To(ContainSubstring("/us-east-1/ec2/"))
+		}
+	})
+
+	It("should fail when the dry run is unauthorized", func() {
+		fakeEC2.DryRunCode = "UnauthorizedOperation"
+
+		_, err := aws.NewProvider(context.Background(), cfg)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should accept endpoints which ignore dry runs", func() {
+		fakeEC2.DryRunCode = ""
+
+		_, err := aws.NewProvider(context.Background(), cfg)
+		Expect(err).To(BeNil())
+	})
+
+	It("should not do a dry run when skipped", func() {
+		fakeEC2.DryRunCode = "UnauthorizedOperation"
+		cfg.SkipDryRun = true
+
+		_, err := aws.NewProvider(context.Background(), cfg)
+		Expect(err).To(BeNil())
+		Expect(fakeEC2.Authorizations()).To(BeEmpty())
+	})
+})
+
+// FakeEC2 serves DescribeInstances from the EC2 query API
+type FakeEC2 struct {
+	// Error code to answer dry runs with, or empty to ignore them
+	DryRunCode string
+	// Instance states by ID
+	Instances map[string]string
+
+	mu             sync.Mutex
+	authorizations []string
+}
+
+func (f *FakeEC2) Authorizations() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.authorizations...)
+}
+
+func (f *FakeEC2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.authorizations = append(f.authorizations, r.Header.Get("Authorization"))
+	f.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		f.error(w, http.StatusBadRequest, "MalformedQueryString", err.Error())
+		return
+	}
+
+	if action := r.PostForm.Get("Action"); action != "DescribeInstances" {
+		f.error(w, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("%s is not supported", action))
+		return
+	}
+
+	if r.PostForm.Get("DryRun") == "true" && f.DryRunCode != "" {
+		f.error(w, http.StatusPreconditionFailed, f.DryRunCode, "Mock dry run")
+		return
+	}
+
+	var items strings.Builder
+	for i := 1; ; i++ {
+		id := r.PostForm.Get(fmt.Sprintf("InstanceId.%d", i))
+		if id == "" {
+			break
+		}
+
+		state, ok := f.Instances[id]
+		if !ok {
+			f.error(w, http.StatusBadRequest, "InvalidInstanceID.NotFound", fmt.Sprintf("The instance ID '%s' does not exist", id))
+			return
+		}
+
+		fmt.Fprintf(&items,
+			"<item><instanceId>%s</instanceId><instanceState><code>0</code><name>%s</name></instanceState></item>",
+			id, state,
+		)
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>fake</requestId>
+  <reservationSet>
+    <item>
+      <reservationId>r-fake</reservationId>
+      <instancesSet>%s</instancesSet>
+    </item>
+  </reservationSet>
+</DescribeInstancesResponse>`, items.String())
+}
+
+func (f *FakeEC2) error(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors><RequestID>fake</RequestID></Response>`,
+		code, message,
+	)
+}
@@ -0,0 +1,256 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-03-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/azure")
+)
+
+// StateMissing is the state of VMs Azure doesn't know about
+const StateMissing = "missing"
+
+// KnownStates are the provisioning and power states in VM instance views,
+// and missing
+var KnownStates = []string{
+	"ProvisioningState/creating",
+	"ProvisioningState/updating",
+	"ProvisioningState/succeeded",
+	"ProvisioningState/failed",
+	"ProvisioningState/canceled",
+	"ProvisioningState/migrating",
+	"ProvisioningState/deleting",
+	"PowerState/starting",
+	"PowerState/running",
+	"PowerState/stopping",
+	"PowerState/stopped",
+	"PowerState/deallocating",
+	"PowerState/deallocated",
+	"PowerState/unknown",
+	StateMissing,
+}
+
+// DefaultGoneStates are the statuses which count as gone unless configured
+// otherwise, so deallocated VMs, which can be started again, are kept
+var DefaultGoneStates = []string{
+	"ProvisioningState/deleting",
+	StateMissing,
+}
+
+// ComputeAPIClient is the part of the ARM compute API used by the provider
+type ComputeAPIClient interface {
+	VirtualMachineInstanceView(ctx context.Context, subscriptionID, resourceGroup, name string) (compute.VirtualMachineInstanceView, error)
+	ScaleSetVMInstanceView(ctx context.Context, subscriptionID, resourceGroup, scaleSet, instanceID string) (compute.VirtualMachineScaleSetVMInstanceView, error)
+}
+
+type Config struct {
+	// Instance view statuses which count as gone, defaults to
+	// DefaultGoneStates
+	GoneStates []string
+}
+
+type Provider struct {
+	Client     ComputeAPIClient
+	GoneStates []string
+}
+
+func NewProvider(cfg *Config) (*Provider, error) {
+	goneStates := cfg.GoneStates
+	if len(goneStates) == 0 {
+		goneStates = DefaultGoneStates
+	}
+
+	if err := skprovider.ValidateGoneStates(goneStates, KnownStates); err != nil {
+		return nil, fmt.Errorf("invalid gone states, %v", err)
+	}
+
+	// Client credentials, certificates, username/password or managed identity
+	settings, err := auth.GetSettingsFromEnvironment()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration, %v", err)
+	}
+
+	authorizer, err := settings.GetAuthorizer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer, %v", err)
+	}
+
+	return &Provider{
+		Client: &computeClient{
+			baseURI:    settings.Environment.ResourceManagerEndpoint,
+			authorizer: authorizer,
+			vms:        map[string]compute.VirtualMachinesClient{},
+			vmssVMs:    map[string]compute.VirtualMachineScaleSetVMsClient{},
+		},
+		GoneStates: goneStates,
+	}, nil
+}
+
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	id, err := parseProviderID(providerID)
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	var statuses *[]compute.InstanceViewStatus
+
+	if id.scaleSet == "" {
+		view, err := provider.Client.VirtualMachineInstanceView(context.TODO(), id.subscriptionID, id.resourceGroup, id.name)
+		if err != nil {
+			return provider.handleError(id, err)
+		}
+		statuses = view.Statuses
+	} else {
+		view, err := provider.Client.ScaleSetVMInstanceView(context.TODO(), id.subscriptionID, id.resourceGroup, id.scaleSet, id.name)
+		if err != nil {
+			return provider.handleError(id, err)
+		}
+		statuses = view.Statuses
+	}
+
+	// Report the power state if there is one, it says the most
+	state := "present"
+	if statuses != nil {
+		for _, status := range *statuses {
+			if status.Code == nil {
+				continue
+			}
+			if skprovider.IsGone(*status.Code, provider.GoneStates, DefaultGoneStates) {
+				log.Info("vm %s has status %s", id, *status.Code)
+				return skprovider.NewVerdict(skprovider.Gone, *status.Code), nil
+			}
+			if state == "present" || strings.HasPrefix(*status.Code, "PowerState/") {
+				state = *status.Code
+			}
+		}
+	}
+
+	return skprovider.NewVerdict(skprovider.Exists, state), nil
+}
+
+// Deal with API errors
+func (provider *Provider) handleError(id *resourceID, err error) (skprovider.Verdict, error) {
+	var apiErr autorest.DetailedError
+
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusNotFound:
+			log.Info("no vm found for %s", id)
+			return skprovider.GoneVerdict(StateMissing, provider.GoneStates, DefaultGoneStates), nil
+		default:
+			log.Error("azure API error - code: %v, message: %v", apiErr.StatusCode, apiErr.Message)
+		}
+	}
+
+	return skprovider.Verdict{}, err
+}
+
+type resourceID struct {
+	subscriptionID string
+	resourceGroup  string
+	scaleSet       string
+	name           string
+}
+
+func (id *resourceID) String() string {
+	if id.scaleSet == "" {
+		return fmt.Sprintf("%s/%s/%s", id.subscriptionID, id.resourceGroup, id.name)
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", id.subscriptionID, id.resourceGroup, id.scaleSet, id.name)
+}
+
+// Provider IDs look like
+// azure:///subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachines/<name>
+// for VMs, or .../virtualMachineScaleSets/<ss>/virtualMachines/<id> for scale set instances
+func parseProviderID(providerID string) (*resourceID, error) {
+	// should have been checked already, being defensive
+	if !strings.HasPrefix(providerID, "azure:///") {
+		return nil, fmt.Errorf("providerID %s does not start with azure:///", providerID)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(providerID, "azure:///"), "/")
+
+	// resource IDs are case insensitive apart from the values
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = strings.ToLower(parts[i])
+	}
+
+	switch {
+	case len(parts) == 8 &&
+		parts[0] == "subscriptions" &&
+		parts[2] == "resourcegroups" &&
+		parts[4] == "providers" &&
+		strings.EqualFold(parts[5], "Microsoft.Compute") &&
+		parts[6] == "virtualmachines":
+		return &resourceID{
+			subscriptionID: parts[1],
+			resourceGroup:  parts[3],
+			name:           parts[7],
+		}, nil
+	case len(parts) == 10 &&
+		parts[0] == "subscriptions" &&
+		parts[2] == "resourcegroups" &&
+		parts[4] == "providers" &&
+		strings.EqualFold(parts[5], "Microsoft.Compute") &&
+		parts[6] == "virtualmachinescalesets" &&
+		parts[8] == "virtualmachines":
+		return &resourceID{
+			subscriptionID: parts[1],
+			resourceGroup:  parts[3],
+			scaleSet:       parts[7],
+			name:           parts[9],
+		}, nil
+	}
+
+	return nil, fmt.Errorf("providerID %s is not an azure virtual machine resource ID", providerID)
+}
+
+// computeClient keeps a client per subscription, since nodes in one cluster
+// can belong to several subscriptions
+type computeClient struct {
+	baseURI    string
+	authorizer autorest.Authorizer
+
+	mu      sync.Mutex
+	vms     map[string]compute.VirtualMachinesClient
+	vmssVMs map[string]compute.VirtualMachineScaleSetVMsClient
+}
+
+func (c *computeClient) VirtualMachineInstanceView(ctx context.Context, subscriptionID, resourceGroup, name string) (compute.VirtualMachineInstanceView, error) {
+	c.mu.Lock()
+	client, ok := c.vms[subscriptionID]
+	if !ok {
+		client = compute.NewVirtualMachinesClientWithBaseURI(c.baseURI, subscriptionID)
+		client.Authorizer = c.authorizer
+		c.vms[subscriptionID] = client
+	}
+	c.mu.Unlock()
+
+	return client.InstanceView(ctx, resourceGroup, name)
+}
+
+func (c *computeClient) ScaleSetVMInstanceView(ctx context.Context, subscriptionID, resourceGroup, scaleSet, instanceID string) (compute.VirtualMachineScaleSetVMInstanceView, error) {
+	c.mu.Lock()
+	client, ok := c.vmssVMs[subscriptionID]
+	if !ok {
+		client = compute.NewVirtualMachineScaleSetVMsClientWithBaseURI(c.baseURI, subscriptionID)
+		client.Authorizer = c.authorizer
+		c.vmssVMs[subscriptionID] = client
+	}
+	c.mu.Unlock()
+
+	return client.GetInstanceView(ctx, resourceGroup, scaleSet, instanceID)
+}
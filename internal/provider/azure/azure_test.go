@@ -0,0 +1,176 @@
+package azure_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/azure"
+
+	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-03-01/compute"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+const (
+	subscriptionID = "00000000-0000-0000-0000-000000000000"
+	resourceGroup  = "my-rg"
+	scaleSet       = "my-ss"
+	runningID      = "node-running"
+	deallocatedID  = "node-deallocated"
+	missingID      = "node-missing"
+	errorID        = "node-error"
+)
+
+var _ = Describe("Azure Provider", func() {
+	var (
+		provider *azure.Provider
+	)
+
+	vmID := func(name string) string {
+		return fmt.Sprintf(
+			"azure:///subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+			subscriptionID, resourceGroup, name,
+		)
+	}
+
+	vmssID := func(name string) string {
+		return fmt.Sprintf(
+			"azure:///subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets/%s/virtualMachines/%s",
+			subscriptionID, resourceGroup, scaleSet, name,
+		)
+	}
+
+	BeforeEach(func() {
+		provider = &azure.Provider{
+			Client: &MockComputeClient{
+				statuses: map[string]string{
+					runningID:     "PowerState/running",
+					deallocatedID: "PowerState/deallocated",
+				},
+			},
+		}
+	})
+
+	for kind, makeID := range map[string]func(string) string{"VM": vmID, "VMSS instance": vmssID} {
+		makeID := makeID
+
+		Describe(fmt.Sprintf("Checking if an Azure %s exists", kind), func() {
+			It("should be true for running VMs", func() {
+				verdict, err := provider.InstanceVerdict(makeID(runningID))
+
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Exists))
+			})
+
+			It("should be true for deallocated VMs, which can be started again", func() {
+				verdict, err := provider.InstanceVerdict(makeID(deallocatedID))
+
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Exists))
+				Expect(verdict.ProviderState).To(Equal("PowerState/deallocated"))
+			})
+
+			It("should follow the configured gone states", func() {
+				provider.GoneStates = []string{"PowerState/deallocated"}
+
+				verdict, err := provider.InstanceVerdict(makeID(deallocatedID))
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Gone))
+
+				verdict, err = provider.InstanceVerdict(makeID(missingID))
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Exists))
+			})
+
+			It("should reject unknown gone states", func() {
+				_, err := azure.NewProvider(&azure.Config{GoneStates: []string{"PowerState/deallocted"}})
+
+				Expect(err).To(MatchError(ContainSubstring(`unknown state "PowerState/deallocted"`)))
+			})
+
+			It("should not be true when the VM was not found", func() {
+				verdict, err := provider.InstanceVerdict(makeID(missingID))
+
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Gone))
+			})
+
+			It("should propagate errors", func() {
+				_, err := provider.InstanceVerdict(makeID(errorID))
+
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	}
+
+	Describe("Parsing provider IDs", func() {
+		It("should ignore the case of resource types", func() {
+			providerID := fmt.Sprintf(
+				"azure:///subscriptions/%s/resourcegroups/%s/providers/microsoft.compute/virtualmachines/%s",
+				subscriptionID, resourceGroup, runningID,
+			)
+			verdict, err := provider.InstanceVerdict(providerID)
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+		})
+
+		It("should reject IDs that aren't virtual machines", func() {
+			providerID := fmt.Sprintf(
+				"azure:///subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/networkInterfaces/%s",
+				subscriptionID, resourceGroup, runningID,
+			)
+			_, err := provider.InstanceVerdict(providerID)
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+type MockComputeClient struct {
+	statuses map[string]string
+}
+
+func (c *MockComputeClient) lookup(sub, rg, name string) (*[]compute.InstanceViewStatus, error) {
+	if name == errorID {
+		return nil, autorest.NewErrorWithError(fmt.Errorf("Mock error"), "compute", "InstanceView", &http.Response{
+			StatusCode: http.StatusForbidden,
+		}, "Mock error")
+	}
+
+	if status, ok := c.statuses[name]; ok && sub == subscriptionID && rg == resourceGroup {
+		return &[]compute.InstanceViewStatus{
+			{Code: to.StringPtr("ProvisioningState/succeeded")},
+			{Code: to.StringPtr(status)},
+		}, nil
+	}
+
+	return nil, autorest.NewErrorWithError(fmt.Errorf("Mock not found error"), "compute", "InstanceView", &http.Response{
+		StatusCode: http.StatusNotFound,
+	}, "Mock not found error")
+}
+
+func (c *MockComputeClient) VirtualMachineInstanceView(ctx context.Context, sub, rg, name string) (compute.VirtualMachineInstanceView, error) {
+	statuses, err := c.lookup(sub, rg, name)
+	if err != nil {
+		return compute.VirtualMachineInstanceView{}, err
+	}
+	return compute.VirtualMachineInstanceView{Statuses: statuses}, nil
+}
+
+func (c *MockComputeClient) ScaleSetVMInstanceView(ctx context.Context, sub, rg, ss, instanceID string) (compute.VirtualMachineScaleSetVMInstanceView, error) {
+	if ss != scaleSet {
+		return compute.VirtualMachineScaleSetVMInstanceView{}, fmt.Errorf("unexpected scale set %s", ss)
+	}
+
+	statuses, err := c.lookup(sub, rg, instanceID)
+	if err != nil {
+		return compute.VirtualMachineScaleSetVMInstanceView{}, err
+	}
+	return compute.VirtualMachineScaleSetVMInstanceView{Statuses: statuses}, nil
+}
@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var cacheRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "skuttle_provider_cache_requests_total",
+		Help: "Provider cache lookups by provider prefix and whether they were answered from the cache.",
+	},
+	[]string{"prefix", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(cacheRequests)
+}
+
+type CacheConfig struct {
+	// How long to remember instances which exist
+	TTL time.Duration
+	// How long to remember instances which don't exist
+	NegativeTTL time.Duration
+	// How long to remember errors and unknown verdicts, so a failing
+	// provider isn't asked again straight away
+	ErrorTTL time.Duration
+}
+
+// CacheStats counts cache lookups
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+type cacheEntry struct {
+	verdict Verdict
+	err     error
+	expires time.Time
+}
+
+// CachingProvider remembers another provider's verdicts by provider ID. A
+// zero TTL turns off caching for that kind of answer.
+type CachingProvider struct {
+	CacheConfig
+	Provider Provider
+	// Used to label metrics
+	Prefix string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	hits    uint64
+	misses  uint64
+}
+
+func NewCachingProvider(p Provider, prefix string, cfg *CacheConfig) *CachingProvider {
+	return &CachingProvider{
+		CacheConfig: *cfg,
+		Provider:    p,
+		Prefix:      prefix,
+		entries:     map[string]cacheEntry{},
+	}
+}
+
+func (c *CachingProvider) InstanceVerdict(providerID string) (Verdict, error) {
+	if entry, ok := c.get(providerID); ok {
+		return entry.verdict, entry.err
+	}
+
+	verdict, err := c.Provider.InstanceVerdict(providerID)
+	c.put(providerID, verdict, err)
+
+	return verdict, err
+}
+
+// InstanceVerdicts answers what it can from the cache and asks the provider
+// about the rest, in one batch if the provider supports it
+func (c *CachingProvider) InstanceVerdicts(providerIDs []string) (map[string]Verdict, error) {
+	verdicts := map[string]Verdict{}
+	var misses []string
+
+	for _, id := range providerIDs {
+		entry, ok := c.get(id)
+		switch {
+		case !ok:
+			misses = append(misses, id)
+		case entry.err != nil:
+			return nil, entry.err
+		default:
+			verdicts[id] = entry.verdict
+		}
+	}
+
+	if len(misses) == 0 {
+		return verdicts, nil
+	}
+
+	found, err := InstanceVerdicts(c.Provider, misses)
+	if err != nil {
+		for _, id := range misses {
+			c.put(id, Verdict{}, err)
+		}
+		return nil, err
+	}
+
+	for id, verdict := range found {
+		c.put(id, verdict, nil)
+		verdicts[id] = verdict
+	}
+
+	return verdicts, nil
+}
+
+// DeleteInstance forgets the instance and passes the deletion on if the
+// provider handles it
+func (c *CachingProvider) DeleteInstance(providerID string) error {
+	c.mu.Lock()
+	delete(c.entries, providerID)
+	c.mu.Unlock()
+
+	if deleter, ok := c.Provider.(InstanceDeleter); ok {
+		return deleter.DeleteInstance(providerID)
+	}
+
+	return nil
+}
+
+// VolumeAttached always asks the provider, as volumes are only checked once
+// a node is deleted
+func (c *CachingProvider) VolumeAttached(providerID, volumeID string) (bool, error) {
+	return VolumeAttached(c.Provider, providerID, volumeID)
+}
+
+// Stats returns the number of cache hits and misses so far
+func (c *CachingProvider) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+func (c *CachingProvider) get(providerID string) (cacheEntry, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[providerID]
+	if ok && !time.Now().Before(entry.expires) {
+		delete(c.entries, providerID)
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+		cacheRequests.WithLabelValues(c.Prefix, "hit").Inc()
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+		cacheRequests.WithLabelValues(c.Prefix, "miss").Inc()
+	}
+
+	return entry, ok
+}
+
+func (c *CachingProvider) put(providerID string, verdict Verdict, err error) {
+	ttl := c.TTL
+	switch {
+	case err != nil:
+		ttl = c.ErrorTTL
+	case verdict.State == Gone:
+		ttl = c.NegativeTTL
+	case verdict.State == Unknown:
+		// Not knowing is more like an error than an answer
+		ttl = c.ErrorTTL
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string]cacheEntry{}
+	}
+
+	c.entries[providerID] = cacheEntry{
+		verdict: verdict,
+		err:     err,
+		expires: time.Now().Add(ttl),
+	}
+}
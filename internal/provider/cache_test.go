@@ -0,0 +1,147 @@
+package provider_test
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vixus0/skuttle/v2/internal/provider"
+)
+
+var _ = Describe("Caching provider", func() {
+	var (
+		fake  *FakeProvider
+		cache *provider.CachingProvider
+	)
+
+	BeforeEach(func() {
+		fake = &FakeProvider{
+			Nodes: map[string]bool{
+				"node-exists":  true,
+				"node-missing": false,
+			},
+		}
+
+		cache = provider.NewCachingProvider(fake, "fake", &provider.CacheConfig{
+			TTL:         time.Minute,
+			NegativeTTL: time.Minute,
+			ErrorTTL:    time.Minute,
+		})
+	})
+
+	Describe("Checking an instance exists", func() {
+		It("should remember instances which exist", func() {
+			Expect(provider.InstanceExists(cache, "fake://node-exists")).To(BeTrue())
+			Expect(provider.InstanceExists(cache, "fake://node-exists")).To(BeTrue())
+
+			Expect(fake.Calls).To(Equal(1))
+			Expect(cache.Stats()).To(Equal(provider.CacheStats{Hits: 1, Misses: 1}))
+		})
+
+		It("should remember instances which don't exist", func() {
+			Expect(provider.InstanceExists(cache, "fake://node-missing")).To(BeFalse())
+			Expect(provider.InstanceExists(cache, "fake://node-missing")).To(BeFalse())
+
+			Expect(fake.Calls).To(Equal(1))
+		})
+
+		It("should remember errors", func() {
+			_, err := provider.InstanceExists(cache, "fake://node-error")
+			Expect(err).To(HaveOccurred())
+			_, err = provider.InstanceExists(cache, "fake://node-error")
+			Expect(err).To(HaveOccurred())
+
+			Expect(fake.Calls).To(Equal(1))
+		})
+
+		It("should ask again once an answer expires", func() {
+			cache.TTL = 10 * time.Millisecond
+
+			Expect(provider.InstanceExists(cache, "fake://node-exists")).To(BeTrue())
+			time.Sleep(20 * time.Millisecond)
+			Expect(provider.InstanceExists(cache, "fake://node-exists")).To(BeTrue())
+
+			Expect(fake.Calls).To(Equal(2))
+			Expect(cache.Stats()).To(Equal(provider.CacheStats{Hits: 0, Misses: 2}))
+		})
+
+		It("should not cache answers with a zero TTL", func() {
+			cache.NegativeTTL = 0
+			cache.ErrorTTL = 0
+
+			Expect(provider.InstanceExists(cache, "fake://node-missing")).To(BeFalse())
+			Expect(provider.InstanceExists(cache, "fake://node-missing")).To(BeFalse())
+			provider.InstanceExists(cache, "fake://node-error")
+			provider.InstanceExists(cache, "fake://node-error")
+
+			Expect(fake.Calls).To(Equal(4))
+		})
+	})
+
+	Describe("Checking several instances at once", func() {
+		It("should only ask about instances it doesn't know", func() {
+			Expect(provider.InstanceExists(cache, "fake://node-exists")).To(BeTrue())
+
+			verdicts, err := cache.InstanceVerdicts([]string{"fake://node-exists", "fake://node-missing"})
+			Expect(err).To(BeNil())
+			Expect(verdicts).To(HaveLen(2))
+			Expect(verdicts["fake://node-exists"].State).To(Equal(provider.Exists))
+			Expect(verdicts["fake://node-missing"].State).To(Equal(provider.Gone))
+
+			Expect(fake.Calls).To(Equal(2))
+			Expect(provider.InstanceExists(cache, "fake://node-missing")).To(BeFalse())
+			Expect(fake.Calls).To(Equal(2))
+		})
+
+		It("should pass batches on to batch providers", func() {
+			batch := &FakeBatchProvider{FakeProvider: *fake}
+			cache.Provider = batch
+
+			_, err := cache.InstanceVerdicts([]string{"fake://node-exists", "fake://node-missing"})
+			Expect(err).To(BeNil())
+			Expect(batch.Batches).To(Equal(1))
+			Expect(batch.Calls).To(Equal(0))
+		})
+	})
+
+	Describe("Deleting an instance", func() {
+		It("should forget the instance", func() {
+			Expect(provider.InstanceExists(cache, "fake://node-missing")).To(BeFalse())
+			Expect(cache.DeleteInstance("fake://node-missing")).To(Succeed())
+			Expect(provider.InstanceExists(cache, "fake://node-missing")).To(BeFalse())
+
+			Expect(fake.Calls).To(Equal(2))
+		})
+	})
+})
+
+type FakeProvider struct {
+	Nodes map[string]bool
+	Calls int
+}
+
+func (p *FakeProvider) InstanceVerdict(providerID string) (provider.Verdict, error) {
+	p.Calls++
+	id := strings.TrimPrefix(providerID, "fake://")
+	if exists, ok := p.Nodes[id]; ok {
+		return provider.VerdictFromExists(exists), nil
+	}
+	return provider.Verdict{}, fmt.Errorf("unknown provider ID: %v", providerID)
+}
+
+type FakeBatchProvider struct {
+	FakeProvider
+	Batches int
+}
+
+func (p *FakeBatchProvider) InstanceVerdicts(providerIDs []string) (map[string]provider.Verdict, error) {
+	p.Batches++
+	verdicts := map[string]provider.Verdict{}
+	for _, id := range providerIDs {
+		verdicts[id] = provider.VerdictFromExists(p.Nodes[strings.TrimPrefix(id, "fake://")])
+	}
+	return verdicts, nil
+}
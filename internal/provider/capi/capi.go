@@ -0,0 +1,175 @@
+package capi
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/capi")
+)
+
+// MachineResource is the Cluster API Machine resource
+var MachineResource = schema.GroupVersionResource{
+	Group:    "cluster.x-k8s.io",
+	Version:  "v1beta1",
+	Resource: "machines",
+}
+
+// Machine phases which mean the infrastructure is gone
+const (
+	PhaseFailed  = "Failed"
+	PhaseDeleted = "Deleted"
+)
+
+type Config struct {
+	// Namespace to look for Machines in, or all namespaces if empty
+	Namespace string
+	// Delete the Machine as well as the node when its instance is gone
+	DeleteMachines bool
+}
+
+type Provider struct {
+	Client         dynamic.Interface
+	Namespace      string
+	DeleteMachines bool
+}
+
+func NewProvider(restConfig *rest.Config, cfg *Config) (*Provider, error) {
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client, %v", err)
+	}
+
+	return &Provider{
+		Client:         client,
+		Namespace:      cfg.Namespace,
+		DeleteMachines: cfg.DeleteMachines,
+	}, nil
+}
+
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	verdicts, err := provider.InstanceVerdicts([]string{providerID})
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	return verdicts[providerID], nil
+}
+
+// InstanceVerdicts checks several instances against a single list of
+// Machines, reporting each Machine's phase
+func (provider *Provider) InstanceVerdicts(providerIDs []string) (map[string]skprovider.Verdict, error) {
+	machines, err := provider.machines()
+	if err != nil {
+		return nil, err
+	}
+
+	verdicts := map[string]skprovider.Verdict{}
+
+	// A missing Machine only means the instance is gone if skuttle can see
+	// the cluster's Machines at all
+	if len(machines) == 0 {
+		log.Warn("no machines found, is the namespace %q right?", provider.Namespace)
+		for _, providerID := range providerIDs {
+			verdicts[providerID] = skprovider.Verdict{
+				State:      skprovider.Unknown,
+				Reason:     "no machines found",
+				ObservedAt: time.Now(),
+			}
+		}
+		return verdicts, nil
+	}
+
+	for _, providerID := range providerIDs {
+		machine, ok := machines[providerID]
+		if !ok {
+			log.Info("no machine found for %s", providerID)
+			verdicts[providerID] = skprovider.Verdict{
+				State:      skprovider.Gone,
+				Reason:     "no machine has this provider ID",
+				ObservedAt: time.Now(),
+			}
+			continue
+		}
+
+		phase, _, _ := unstructured.NestedString(machine.Object, "status", "phase")
+		log.Debug("machine %s/%s for %s is %s", machine.GetNamespace(), machine.GetName(), providerID, phase)
+
+		switch phase {
+		case PhaseFailed, PhaseDeleted:
+			log.Info("machine %s/%s for %s is %s", machine.GetNamespace(), machine.GetName(), providerID, phase)
+			verdicts[providerID] = skprovider.NewVerdict(skprovider.Gone, phase)
+		case "":
+			verdicts[providerID] = skprovider.Verdict{
+				State:      skprovider.Exists,
+				Reason:     "machine has no phase yet",
+				ObservedAt: time.Now(),
+			}
+		default:
+			verdicts[providerID] = skprovider.NewVerdict(skprovider.Exists, phase)
+		}
+	}
+
+	return verdicts, nil
+}
+
+// DeleteInstance deletes the Machine for an instance, if configured to
+func (provider *Provider) DeleteInstance(providerID string) error {
+	if !provider.DeleteMachines {
+		return nil
+	}
+
+	machines, err := provider.machines()
+	if err != nil {
+		return err
+	}
+
+	machine, ok := machines[providerID]
+	if !ok {
+		return nil
+	}
+
+	log.Info("deleting machine %s/%s", machine.GetNamespace(), machine.GetName())
+	err = provider.Client.Resource(MachineResource).Namespace(machine.GetNamespace()).
+		Delete(context.TODO(), machine.GetName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete machine %s/%s, %v", machine.GetNamespace(), machine.GetName(), err)
+	}
+
+	return nil
+}
+
+// machines lists Machines keyed by their provider ID
+func (provider *Provider) machines() (map[string]*unstructured.Unstructured, error) {
+	list, err := provider.Client.Resource(MachineResource).Namespace(provider.Namespace).
+		List(context.TODO(), metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		return nil, fmt.Errorf("not allowed to list machines, check skuttle's RBAC rules, %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list machines, %v", err)
+	}
+
+	machines := map[string]*unstructured.Unstructured{}
+	for i := range list.Items {
+		machine := &list.Items[i]
+		providerID, _, _ := unstructured.NestedString(machine.Object, "spec", "providerID")
+		if providerID != "" {
+			machines[providerID] = machine
+		}
+	}
+
+	return machines, nil
+}
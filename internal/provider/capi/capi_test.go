@@ -0,0 +1,150 @@
+package capi_test
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/capi"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Cluster API provider", func() {
+	var (
+		client   *dynamicfake.FakeDynamicClient
+		provider *capi.Provider
+	)
+
+	BeforeEach(func() {
+		client = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+			runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{
+				capi.MachineResource: "MachineList",
+			},
+			Machine("default", "running", "aws:///us-east-1a/i-running", "Running"),
+			Machine("default", "provisioning", "aws:///us-east-1a/i-provisioning", "Provisioning"),
+			Machine("other", "failed", "aws:///us-east-1a/i-failed", "Failed"),
+			Machine("default", "deleted", "aws:///us-east-1a/i-deleted", "Deleted"),
+		)
+
+		provider = &capi.Provider{
+			Client: client,
+		}
+	})
+
+	Describe("Checking an instance exists", func() {
+		It("should be true for machines which are up", func() {
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-running")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-provisioning")).To(BeTrue())
+		})
+
+		It("should be false for failed or deleted machines", func() {
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-failed")).To(BeFalse())
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-deleted")).To(BeFalse())
+		})
+
+		It("should be false without a machine", func() {
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-missing")).To(BeFalse())
+		})
+
+		It("should only look in the configured namespace", func() {
+			provider.Namespace = "default"
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-running")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-failed")).To(BeFalse())
+		})
+
+		It("should check several instances at once", func() {
+			verdicts, err := provider.InstanceVerdicts([]string{
+				"aws:///us-east-1a/i-running",
+				"aws:///us-east-1a/i-failed",
+				"aws:///us-east-1a/i-missing",
+			})
+
+			Expect(err).To(BeNil())
+			Expect(States(verdicts)).To(Equal(map[string]skprovider.State{
+				"aws:///us-east-1a/i-running": skprovider.Exists,
+				"aws:///us-east-1a/i-failed":  skprovider.Gone,
+				"aws:///us-east-1a/i-missing": skprovider.Gone,
+			}))
+		})
+	})
+
+	Describe("Failing to see machines", func() {
+		It("should not know without any machines", func() {
+			provider.Namespace = "empty"
+			verdict, err := provider.InstanceVerdict("aws:///us-east-1a/i-missing")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Unknown))
+		})
+
+		It("should return an error when not allowed to list machines", func() {
+			client.PrependReactor("list", "machines", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(capi.MachineResource.GroupResource(), "", fmt.Errorf("rbac"))
+			})
+			_, err := provider.InstanceVerdict("aws:///us-east-1a/i-missing")
+
+			Expect(err).To(MatchError(ContainSubstring("RBAC")))
+		})
+	})
+
+	Describe("Deleting an instance", func() {
+		It("should leave the machine alone by default", func() {
+			Expect(provider.DeleteInstance("aws:///us-east-1a/i-failed")).To(Succeed())
+
+			_, err := client.Resource(capi.MachineResource).Namespace("other").Get(context.TODO(), "failed", metav1.GetOptions{})
+			Expect(err).To(BeNil())
+		})
+
+		It("should delete the machine when configured to", func() {
+			provider.DeleteMachines = true
+			Expect(provider.DeleteInstance("aws:///us-east-1a/i-failed")).To(Succeed())
+
+			_, err := client.Resource(capi.MachineResource).Namespace("other").Get(context.TODO(), "failed", metav1.GetOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should succeed without a machine", func() {
+			provider.DeleteMachines = true
+			Expect(provider.DeleteInstance("aws:///us-east-1a/i-missing")).To(Succeed())
+		})
+	})
+})
+
+func Machine(namespace, name, providerID, phase string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       "Machine",
+			"metadata": map[string]interface{}{
+				"namespace": namespace,
+				"name":      name,
+			},
+			"spec": map[string]interface{}{
+				"providerID": providerID,
+			},
+			"status": map[string]interface{}{
+				"phase": phase,
+			},
+		},
+	}
+}
+
+// States picks the state out of each verdict
+func States(verdicts map[string]skprovider.Verdict) map[string]skprovider.State {
+	states := map[string]skprovider.State{}
+	for id, verdict := range verdicts {
+		states[id] = verdict.State
+	}
+	return states
+}
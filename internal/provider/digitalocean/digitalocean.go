@@ -0,0 +1,106 @@
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	"github.com/digitalocean/godo"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/digitalocean")
+)
+
+// Droplet statuses
+const (
+	StatusNew     = "new"
+	StatusActive  = "active"
+	StatusOff     = "off"
+	StatusArchive = "archive"
+)
+
+// StatusMissing is the status of droplets DigitalOcean doesn't know about
+const StatusMissing = "missing"
+
+// KnownStates are the droplet statuses, and missing
+var KnownStates = []string{
+	StatusNew,
+	StatusActive,
+	StatusOff,
+	StatusArchive,
+	StatusMissing,
+}
+
+// DefaultGoneStates are the droplet statuses which count as gone unless
+// configured otherwise, so droplets which are off or archived, and can be
+// powered on or restored, are kept
+var DefaultGoneStates = []string{
+	StatusMissing,
+}
+
+// DropletsAPIClient is the part of the godo droplets service we use
+type DropletsAPIClient interface {
+	Get(ctx context.Context, id int) (*godo.Droplet, *godo.Response, error)
+}
+
+type Config struct {
+	// Droplet statuses which count as gone, defaults to DefaultGoneStates
+	GoneStates []string
+}
+
+type Provider struct {
+	Client     DropletsAPIClient
+	GoneStates []string
+}
+
+func NewProvider(cfg *Config) (*Provider, error) {
+	if err := skprovider.ValidateGoneStates(cfg.GoneStates, KnownStates); err != nil {
+		return nil, fmt.Errorf("invalid gone states, %v", err)
+	}
+
+	token, ok := os.LookupEnv("DIGITALOCEAN_ACCESS_TOKEN")
+	if !ok {
+		return nil, fmt.Errorf("Need to specify an API token in DIGITALOCEAN_ACCESS_TOKEN")
+	}
+
+	client := godo.NewFromToken(token)
+
+	return &Provider{
+		Client:     client.Droplets,
+		GoneStates: cfg.GoneStates,
+	}, nil
+}
+
+// InstanceVerdict reports the droplet's status along with whether it counts
+// as gone
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	// should have been checked already, being defensive
+	if !strings.HasPrefix(providerID, "digitalocean://") {
+		return skprovider.Verdict{}, fmt.Errorf("providerID %s does not start with digitalocean://", providerID)
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(providerID, "digitalocean://"))
+	if err != nil {
+		return skprovider.Verdict{}, fmt.Errorf("providerID %s does not have a numeric droplet ID", providerID)
+	}
+
+	droplet, resp, err := provider.Client.Get(context.TODO(), id)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			log.Info("no droplet found for ID %d", id)
+			return skprovider.GoneVerdict(StatusMissing, provider.GoneStates, DefaultGoneStates), nil
+		}
+		return skprovider.Verdict{}, fmt.Errorf("failed to get droplet %d, %v", id, err)
+	}
+
+	log.Debug("droplet %d is %s", id, droplet.Status)
+
+	return skprovider.GoneVerdict(droplet.Status, provider.GoneStates, DefaultGoneStates), nil
+}
@@ -0,0 +1,111 @@
+package digitalocean_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/digitalocean"
+
+	"github.com/digitalocean/godo"
+)
+
+var _ = Describe("DigitalOcean provider", func() {
+	var (
+		server   *httptest.Server
+		provider *digitalocean.Provider
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(&MockDigitalOcean{
+			Droplets: map[string]string{
+				"1": "active",
+				"2": "new",
+				"3": "off",
+				"4": "archive",
+			},
+		})
+
+		client, err := godo.New(http.DefaultClient, godo.SetBaseURL(server.URL))
+		Expect(err).To(BeNil())
+
+		provider = &digitalocean.Provider{
+			Client: client.Droplets,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Checking a droplet exists", func() {
+		It("should be true for active droplets", func() {
+			Expect(skprovider.InstanceExists(provider, "digitalocean://1")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "digitalocean://2")).To(BeTrue())
+		})
+
+		It("should be true for droplets which are off or archived, which can be brought back", func() {
+			Expect(skprovider.InstanceExists(provider, "digitalocean://3")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "digitalocean://4")).To(BeTrue())
+		})
+
+		It("should follow the configured gone states", func() {
+			provider.GoneStates = []string{"archive"}
+
+			Expect(skprovider.InstanceExists(provider, "digitalocean://3")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "digitalocean://4")).To(BeFalse())
+			Expect(skprovider.InstanceExists(provider, "digitalocean://5")).To(BeTrue())
+		})
+
+		It("should reject unknown gone states", func() {
+			_, err := digitalocean.NewProvider(&digitalocean.Config{GoneStates: []string{"archived"}})
+
+			Expect(err).To(MatchError(ContainSubstring(`unknown state "archived"`)))
+		})
+
+		It("should be false for missing droplets", func() {
+			Expect(skprovider.InstanceExists(provider, "digitalocean://5")).To(BeFalse())
+		})
+
+		It("should propagate errors", func() {
+			_, err := provider.InstanceVerdict("digitalocean://666")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject invalid provider IDs", func() {
+			_, err := provider.InstanceVerdict("digitalocean://droplet")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+// MockDigitalOcean serves droplet statuses from the DigitalOcean API
+type MockDigitalOcean struct {
+	Droplets map[string]string
+}
+
+func (m *MockDigitalOcean) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := strings.TrimPrefix(r.URL.Path, "/v2/droplets/")
+
+	if id == "666" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"id": "service_unavailable", "message": "Mock error"}`)
+		return
+	}
+
+	status, ok := m.Droplets[id]
+	if r.Method != http.MethodGet || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"id": "not_found", "message": "Mock droplet not found"}`)
+		return
+	}
+
+	fmt.Fprintf(w, `{"droplet": {"id": %s, "name": "droplet-%s", "status": "%s"}}`, id, id, status)
+}
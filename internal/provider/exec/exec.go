@@ -0,0 +1,134 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	osexec "os/exec"
+	"strings"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/exec")
+)
+
+// States a plugin can report for an instance
+const (
+	StateExists  = "exists"
+	StateMissing = "missing"
+	StateUnknown = "unknown"
+)
+
+// Verdict is what a plugin writes to stdout
+type Verdict struct {
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Provider runs an external plugin binary for every instance lookup.
+//
+// The plugin is given the provider ID as its only argument and on stdin.
+// It must exit 0 and write a JSON Verdict to stdout, any other exit code is
+// treated as an error. A verdict of "unknown" is passed on as such, so the
+// node is left alone.
+type Provider struct {
+	Path    string
+	Timeout time.Duration
+}
+
+func NewProvider(path string, timeout time.Duration) (*Provider, error) {
+	resolved, err := osexec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s is not executable, %v", path, err)
+	}
+
+	return &Provider{
+		Path:    resolved,
+		Timeout: timeout,
+	}, nil
+}
+
+// InstanceVerdict runs the plugin and passes on its verdict, including when
+// it doesn't know the state of the instance
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	ctx := context.Background()
+	if provider.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, provider.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := osexec.CommandContext(ctx, provider.Path, providerID)
+	cmd.Stdin = strings.NewReader(providerID + "\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	log.Debug("running %s %s", provider.Path, providerID)
+	if err := cmd.Start(); err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	// Don't wait on any children the plugin left holding stdout after the
+	// plugin itself was killed
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return skprovider.Verdict{}, fmt.Errorf("plugin %s timed out after %s for %s", provider.Path, provider.Timeout, providerID)
+	}
+
+	if err != nil {
+		var exitErr *osexec.ExitError
+
+		if errors.As(err, &exitErr) {
+			return skprovider.Verdict{}, fmt.Errorf(
+				"plugin %s exited with code %d for %s: %s",
+				provider.Path,
+				exitErr.ExitCode(),
+				providerID,
+				strings.TrimSpace(stderr.String()),
+			)
+		}
+
+		return skprovider.Verdict{}, err
+	}
+
+	var verdict Verdict
+	if err := json.Unmarshal(stdout.Bytes(), &verdict); err != nil {
+		return skprovider.Verdict{}, fmt.Errorf("plugin %s returned an invalid verdict for %s: %v", provider.Path, providerID, err)
+	}
+
+	result := skprovider.Verdict{
+		Reason:        verdict.Reason,
+		ProviderState: verdict.State,
+		ObservedAt:    time.Now(),
+	}
+
+	switch verdict.State {
+	case StateExists:
+		result.State = skprovider.Exists
+		return result, nil
+	case StateMissing:
+		log.Info("plugin reports %s missing: %s", providerID, verdict.Reason)
+		result.State = skprovider.Gone
+		return result, nil
+	case StateUnknown:
+		result.State = skprovider.Unknown
+		return result, nil
+	}
+
+	return skprovider.Verdict{}, fmt.Errorf("plugin %s returned unknown state %q for %s", provider.Path, verdict.State, providerID)
+}
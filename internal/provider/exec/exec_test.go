@@ -0,0 +1,114 @@
+package exec_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/exec"
+)
+
+// Answers based on the provider ID in $1, and checks it was also on stdin
+const plugin = `#!/bin/sh
+read stdin
+if [ "$stdin" != "$1" ]; then
+  echo "stdin does not match args" >&2
+  exit 3
+fi
+
+case "$1" in
+  metal://node-exists)  echo '{"state": "exists"}' ;;
+  metal://node-missing) echo '{"state": "missing", "reason": "decommissioned"}' ;;
+  metal://node-unknown) echo '{"state": "unknown", "reason": "bmc unreachable"}' ;;
+  metal://node-bogus)   echo '{"state": "bogus"}' ;;
+  metal://node-garbage) echo 'not json' ;;
+  metal://node-slow)    sleep 5; echo '{"state": "exists"}' ;;
+  *)                    echo "no such node $1" >&2; exit 1 ;;
+esac
+`
+
+var _ = Describe("Exec provider", func() {
+	var (
+		dir      string
+		provider *exec.Provider
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		dir, err = ioutil.TempDir("", "skuttle-exec")
+		Expect(err).To(BeNil())
+
+		path := filepath.Join(dir, "metal-plugin")
+		Expect(ioutil.WriteFile(path, []byte(plugin), 0755)).To(Succeed())
+
+		provider, err = exec.NewProvider(path, time.Second)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Creating a provider", func() {
+		It("should fail when the plugin is not executable", func() {
+			_, err := exec.NewProvider(filepath.Join(dir, "nonexistent"), time.Second)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Checking an instance exists", func() {
+		It("should be true when the plugin reports it exists", func() {
+			verdict, err := provider.InstanceVerdict("metal://node-exists")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+		})
+
+		It("should not be true when the plugin reports it missing", func() {
+			verdict, err := provider.InstanceVerdict("metal://node-missing")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Gone))
+		})
+
+		It("should pass on the plugin's verdict when it doesn't know", func() {
+			verdict, err := provider.InstanceVerdict("metal://node-unknown")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Unknown))
+			Expect(verdict.Reason).To(Equal("bmc unreachable"))
+		})
+
+		It("should return an error for unrecognised states", func() {
+			_, err := provider.InstanceVerdict("metal://node-bogus")
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error when the verdict is not JSON", func() {
+			_, err := provider.InstanceVerdict("metal://node-garbage")
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return an error when the plugin exits non-zero", func() {
+			_, err := provider.InstanceVerdict("metal://node-other")
+
+			Expect(err).To(MatchError(ContainSubstring("exited with code 1")))
+			Expect(err).To(MatchError(ContainSubstring("no such node")))
+		})
+
+		It("should return an error when the plugin times out", func() {
+			provider.Timeout = 100 * time.Millisecond
+			_, err := provider.InstanceVerdict("metal://node-slow")
+
+			Expect(err).To(MatchError(ContainSubstring("timed out")))
+		})
+	})
+})
@@ -2,47 +2,328 @@ package file
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	"github.com/fsnotify/fsnotify"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/file")
 )
 
+type State string
+
+const (
+	Running    State = "running"
+	Stopped    State = "stopped"
+	Terminated State = "terminated"
+	// Instances which aren't listed
+	Missing State = "missing"
+)
+
+// Document is the structure of JSON and YAML node lists
+type Document struct {
+	Instances []Instance `json:"instances"`
+}
+
+type Instance struct {
+	ID string `json:"id"`
+	// Defaults to running
+	State State `json:"state,omitempty"`
+}
+
 type Provider struct {
-	Nodes []string
+	Path         string
+	PollInterval time.Duration
+	// Accept a file listing no instances, which would otherwise be taken for
+	// a half-written file and ignored
+	AllowEmpty bool
+	// Largest percentage of known instances a reload can remove before it is
+	// taken for a truncated file and ignored, or no limit if zero
+	MaxShrinkPercent float64
+	// How long to wait for changes to settle before reloading, defaults to
+	// 250ms
+	Debounce time.Duration
+
+	mu        sync.RWMutex
+	instances map[string]State
 }
 
-func NewProvider() (*Provider, error) {
+func NewProvider(ctx context.Context) (*Provider, error) {
 	path, ok := os.LookupEnv("NODE_LIST")
 	if !ok {
 		return nil, fmt.Errorf("Need to specify path to node list in NODE_LIST")
 	}
 
-	file, err := os.Open(path)
-	defer file.Close()
-	if err != nil {
-		return nil, err
+	interval := 10 * time.Second
+	if val, ok := os.LookupEnv("NODE_LIST_POLL_INTERVAL"); ok {
+		var err error
+		if interval, err = time.ParseDuration(val); err != nil {
+			return nil, fmt.Errorf("invalid NODE_LIST_POLL_INTERVAL, %v", err)
+		}
 	}
 
-	var nodes []string
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
+	allowEmpty := false
+	if val, ok := os.LookupEnv("NODE_LIST_ALLOW_EMPTY"); ok {
+		var err error
+		if allowEmpty, err = strconv.ParseBool(val); err != nil {
+			return nil, fmt.Errorf("invalid NODE_LIST_ALLOW_EMPTY, %v", err)
+		}
+	}
 
-	for scanner.Scan() {
-		nodes = append(nodes, strings.TrimSpace(scanner.Text()))
+	maxShrink := 0.0
+	if val, ok := os.LookupEnv("NODE_LIST_MAX_SHRINK_PERCENT"); ok {
+		var err error
+		if maxShrink, err = strconv.ParseFloat(val, 64); err != nil {
+			return nil, fmt.Errorf("invalid NODE_LIST_MAX_SHRINK_PERCENT, %v", err)
+		}
+	}
+
+	provider := &Provider{
+		Path:             path,
+		PollInterval:     interval,
+		AllowEmpty:       allowEmpty,
+		MaxShrinkPercent: maxShrink,
 	}
 
+	if err := provider.Reload(); err != nil {
+		return nil, err
+	}
+
+	provider.Watch(ctx)
+
+	return provider, nil
+}
+
+// NewProviderFromInstances creates a provider which doesn't read a file
+func NewProviderFromInstances(instances map[string]State) *Provider {
 	return &Provider{
-		Nodes: nodes,
-	}, nil
+		instances: instances,
+	}
 }
 
-func (provider *Provider) InstanceExists(providerID string) (bool, error) {
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
 	noPrefixID := strings.TrimPrefix(providerID, "file://")
 
-	for _, id := range provider.Nodes {
-		if id == noPrefixID {
-			return true, nil
+	provider.mu.RLock()
+	state, ok := provider.instances[noPrefixID]
+	provider.mu.RUnlock()
+
+	if !ok {
+		state = Missing
+	}
+
+	if state == Terminated || state == Missing {
+		return skprovider.NewVerdict(skprovider.Gone, string(state)), nil
+	}
+
+	return skprovider.NewVerdict(skprovider.Exists, string(state)), nil
+}
+
+// Reload replaces the node list with the file's contents, keeping the old
+// list if the file can't be read, or looks empty or truncated
+func (provider *Provider) Reload() error {
+	data, err := ioutil.ReadFile(provider.Path)
+	if err != nil {
+		return err
+	}
+
+	instances, err := Parse(provider.Path, data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", provider.Path, err)
+	}
+
+	if len(instances) == 0 && !provider.AllowEmpty {
+		return fmt.Errorf("%s lists no instances", provider.Path)
+	}
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.MaxShrinkPercent > 0 && len(provider.instances) > 0 {
+		removed := provider.removed(instances)
+		percent := float64(removed) / float64(len(provider.instances)) * 100
+		if percent > provider.MaxShrinkPercent {
+			return fmt.Errorf(
+				"%s removes %d of %d instances (limit %g%%)",
+				provider.Path,
+				removed,
+				len(provider.instances),
+				provider.MaxShrinkPercent,
+			)
+		}
+	}
+
+	provider.instances = instances
+
+	log.Debug("loaded %d instances from %s", len(instances), provider.Path)
+	return nil
+}
+
+// How many known instances aren't in a new list
+func (provider *Provider) removed(instances map[string]State) int {
+	removed := 0
+	for id := range provider.instances {
+		if _, ok := instances[id]; !ok {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Watch starts reloading the file whenever it changes until the context is
+// done, falling back to polling if it can't be watched
+func (provider *Provider) Watch(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Warn("could not watch %s, polling instead: %v", provider.Path, err)
+		provider.Poll(ctx)
+		return
+	}
+
+	// Watch the directory, since files are usually replaced rather than
+	// written to, and ConfigMap volumes swap a symlink
+	if err := watcher.Add(filepath.Dir(provider.Path)); err != nil {
+		watcher.Close()
+		log.Warn("could not watch %s, polling instead: %v", provider.Path, err)
+		provider.Poll(ctx)
+		return
+	}
+
+	debounce := provider.Debounce
+	if debounce <= 0 {
+		debounce = 250 * time.Millisecond
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		// Wait for writes to settle, so a file being written isn't loaded
+		// half way through
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				log.Debug("%s: %s", event.Name, event.Op)
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(debounce)
+			case <-timer.C:
+				provider.reloadLogged()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error("error watching %s: %v", provider.Path, err)
+			}
+		}
+	}()
+}
+
+// Poll starts reloading the file whenever its modification time or size
+// changes until the context is done
+func (provider *Provider) Poll(ctx context.Context) {
+	interval := provider.PollInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	var last os.FileInfo
+	if info, err := os.Stat(provider.Path); err == nil {
+		last = info
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				info, err := os.Stat(provider.Path)
+				if err != nil {
+					log.Error("error polling %s: %v", provider.Path, err)
+					continue
+				}
+				if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+					last = info
+					provider.reloadLogged()
+				}
+			}
 		}
+	}()
+}
+
+func (provider *Provider) reloadLogged() {
+	if err := provider.Reload(); err != nil {
+		log.Error("keeping previous node list: %v", err)
 	}
-	return false, nil
+}
+
+// Parse reads JSON or YAML documents, depending on the file extension, or
+// otherwise a list of running instance IDs, one per line
+func Parse(path string, data []byte) (map[string]State, error) {
+	instances := map[string]State{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".yaml", ".yml":
+		var doc Document
+		if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+			return nil, err
+		}
+
+		for _, instance := range doc.Instances {
+			if instance.ID == "" {
+				return nil, fmt.Errorf("instance without an id")
+			}
+
+			switch instance.State {
+			case "":
+				instance.State = Running
+			case Running, Stopped, Terminated:
+			default:
+				return nil, fmt.Errorf("instance %s has unknown state %q", instance.ID, instance.State)
+			}
+
+			instances[instance.ID] = instance.State
+		}
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Split(bufio.ScanLines)
+
+		for scanner.Scan() {
+			if id := strings.TrimSpace(scanner.Text()); id != "" {
+				instances[id] = Running
+			}
+		}
+	}
+
+	return instances, nil
 }
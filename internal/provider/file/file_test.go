@@ -1,9 +1,16 @@
 package file_test
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
 	"github.com/vixus0/skuttle/v2/internal/provider/file"
 )
 
@@ -13,19 +20,223 @@ var _ = Describe("File provider", func() {
 	)
 
 	BeforeEach(func() {
-		provider = &file.Provider{
-			Nodes: []string{
-				"node1",
-				"node2",
-			},
-		}
+		provider = file.NewProviderFromInstances(map[string]file.State{
+			"node1": file.Running,
+			"node2": file.Running,
+		})
 	})
 
 	Describe("Checking an instance exists", func() {
 		It("should be true only for instances in the node list", func() {
-			Expect(provider.InstanceExists("file://node1")).To(BeTrue())
-			Expect(provider.InstanceExists("file://node2")).To(BeTrue())
-			Expect(provider.InstanceExists("file://node3")).To(BeFalse())
+			Expect(skprovider.InstanceExists(provider, "file://node1")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "file://node2")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "file://node3")).To(BeFalse())
+		})
+
+		It("should report stopped instances as existing with their state", func() {
+			provider = file.NewProviderFromInstances(map[string]file.State{
+				"node1": file.Stopped,
+			})
+			verdict, err := provider.InstanceVerdict("file://node1")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+			Expect(verdict.ProviderState).To(Equal("stopped"))
+		})
+	})
+
+	Describe("Parsing node lists", func() {
+		It("should read one running instance per line", func() {
+			instances, err := file.Parse("nodes", []byte("node1\n  node2 \n\n"))
+
+			Expect(err).To(BeNil())
+			Expect(instances).To(Equal(map[string]file.State{
+				"node1": file.Running,
+				"node2": file.Running,
+			}))
+		})
+
+		It("should read instance states from YAML", func() {
+			instances, err := file.Parse("nodes.yaml", []byte(`
+instances:
+  - id: node1
+  - id: node2
+    state: stopped
+  - id: node3
+    state: terminated
+`))
+
+			Expect(err).To(BeNil())
+			Expect(instances).To(Equal(map[string]file.State{
+				"node1": file.Running,
+				"node2": file.Stopped,
+				"node3": file.Terminated,
+			}))
+		})
+
+		It("should read instance states from JSON", func() {
+			instances, err := file.Parse("nodes.json", []byte(`{"instances": [{"id": "node1", "state": "running"}]}`))
+
+			Expect(err).To(BeNil())
+			Expect(instances).To(Equal(map[string]file.State{
+				"node1": file.Running,
+			}))
+		})
+
+		It("should reject unknown states", func() {
+			_, err := file.Parse("nodes.yaml", []byte("instances: [{id: node1, state: exploded}]"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject unknown fields", func() {
+			_, err := file.Parse("nodes.yaml", []byte("nodes: [node1]"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Instance states", func() {
+		It("should only be false for terminated instances", func() {
+			provider = file.NewProviderFromInstances(map[string]file.State{
+				"node1": file.Running,
+				"node2": file.Stopped,
+				"node3": file.Terminated,
+			})
+
+			Expect(skprovider.InstanceExists(provider, "file://node1")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "file://node2")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "file://node3")).To(BeFalse())
+		})
+	})
+
+	Describe("Reloading the node list", func() {
+		var (
+			dir    string
+			path   string
+			ctx    context.Context
+			cancel context.CancelFunc
+		)
+
+		// Replace the file like most tools do, rather than writing in place
+		replace := func(contents string) {
+			tmp := filepath.Join(dir, "tmp")
+			Expect(ioutil.WriteFile(tmp, []byte(contents), 0644)).To(Succeed())
+			Expect(os.Rename(tmp, path)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			var err error
+
+			dir, err = ioutil.TempDir("", "skuttle-file")
+			Expect(err).To(BeNil())
+
+			path = filepath.Join(dir, "nodes.yaml")
+			Expect(ioutil.WriteFile(path, []byte("instances: [{id: node1}]"), 0644)).To(Succeed())
+
+			os.Setenv("NODE_LIST", path)
+			os.Setenv("NODE_LIST_POLL_INTERVAL", "50ms")
+
+			ctx, cancel = context.WithCancel(context.Background())
+		})
+
+		AfterEach(func() {
+			cancel()
+			os.Unsetenv("NODE_LIST")
+			os.Unsetenv("NODE_LIST_POLL_INTERVAL")
+			os.RemoveAll(dir)
+		})
+
+		It("should pick up changes to the file", func() {
+			provider, err := file.NewProvider(ctx)
+			Expect(err).To(BeNil())
+			Expect(skprovider.InstanceExists(provider, "file://node1")).To(BeTrue())
+
+			replace("instances: [{id: node1, state: terminated}]")
+
+			Eventually(func() (bool, error) {
+				return skprovider.InstanceExists(provider, "file://node1")
+			}).Should(BeFalse())
+		})
+
+		It("should keep the previous list when the file is invalid", func() {
+			provider, err := file.NewProvider(ctx)
+			Expect(err).To(BeNil())
+
+			replace("instances: [{id: node1, state: exploded}]")
+
+			Consistently(func() (bool, error) {
+				return skprovider.InstanceExists(provider, "file://node1")
+			}, 300*time.Millisecond).Should(BeTrue())
+		})
+
+		It("should keep the previous list when the file is empty", func() {
+			provider, err := file.NewProvider(ctx)
+			Expect(err).To(BeNil())
+
+			replace("")
+
+			Consistently(func() (bool, error) {
+				return skprovider.InstanceExists(provider, "file://node1")
+			}, 500*time.Millisecond).Should(BeTrue())
+		})
+
+		It("should accept an empty file when allowed", func() {
+			os.Setenv("NODE_LIST_ALLOW_EMPTY", "true")
+			defer os.Unsetenv("NODE_LIST_ALLOW_EMPTY")
+
+			provider, err := file.NewProvider(ctx)
+			Expect(err).To(BeNil())
+
+			replace("instances: []")
+
+			Eventually(func() (bool, error) {
+				return skprovider.InstanceExists(provider, "file://node1")
+			}).Should(BeFalse())
+		})
+
+		It("should accept instances disappearing without a limit", func() {
+			replace("instances: [{id: node1}, {id: node2}, {id: node3}]")
+			provider, err := file.NewProvider(ctx)
+			Expect(err).To(BeNil())
+
+			replace("instances: [{id: node1}]")
+
+			Eventually(func() (bool, error) {
+				return skprovider.InstanceExists(provider, "file://node2")
+			}).Should(BeFalse())
+		})
+
+		It("should keep the previous list when too many instances disappear", func() {
+			provider := &file.Provider{
+				Path:             path,
+				MaxShrinkPercent: 50,
+			}
+			replace("instances: [{id: node1}, {id: node2}, {id: node3}]")
+			Expect(provider.Reload()).To(Succeed())
+
+			replace("instances: [{id: node1}]")
+			Expect(provider.Reload()).NotTo(Succeed())
+			Expect(skprovider.InstanceExists(provider, "file://node2")).To(BeTrue())
+
+			replace("instances: [{id: node1}, {id: node2}]")
+			Expect(provider.Reload()).To(Succeed())
+			Expect(skprovider.InstanceExists(provider, "file://node3")).To(BeFalse())
+		})
+
+		It("should pick up changes by polling", func() {
+			provider := &file.Provider{
+				Path:         path,
+				PollInterval: 50 * time.Millisecond,
+			}
+			Expect(provider.Reload()).To(Succeed())
+			provider.Poll(ctx)
+
+			// Make sure the modification time moves on
+			time.Sleep(10 * time.Millisecond)
+			replace("instances: [{id: node1}, {id: node2}]")
+
+			Eventually(func() (bool, error) {
+				return skprovider.InstanceExists(provider, "file://node2")
+			}).Should(BeTrue())
 		})
 	})
 })
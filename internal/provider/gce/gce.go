@@ -0,0 +1,99 @@
+package gce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/gce")
+)
+
+// StatusMissing is the status of instances GCE doesn't know about
+const StatusMissing = "MISSING"
+
+// InstancesAPIClient is the part of the Compute API used by the provider
+type InstancesAPIClient interface {
+	GetInstance(ctx context.Context, project, zone, name string) (*compute.Instance, error)
+}
+
+type Provider struct {
+	Client InstancesAPIClient
+}
+
+func NewProvider(ctx context.Context) (*Provider, error) {
+	// Uses application default credentials
+	svc, err := compute.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client, %v", err)
+	}
+
+	return &Provider{
+		Client: &computeClient{svc},
+	}, nil
+}
+
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	project, zone, name, err := parseProviderID(providerID)
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	instance, err := provider.Client.GetInstance(context.TODO(), project, zone, name)
+
+	// Deal with API errors
+	if err != nil {
+		var apiErr *googleapi.Error
+
+		if errors.As(err, &apiErr) {
+			switch apiErr.Code {
+			case http.StatusNotFound:
+				log.Info("no instance found for %s/%s/%s", project, zone, name)
+				return skprovider.NewVerdict(skprovider.Gone, StatusMissing), nil
+			default:
+				log.Error("gce API error - code: %v, message: %v", apiErr.Code, apiErr.Message)
+			}
+		}
+
+		return skprovider.Verdict{}, err
+	}
+
+	if instance.Status == "TERMINATED" {
+		log.Info("instance %s/%s/%s is terminated", project, zone, name)
+		return skprovider.NewVerdict(skprovider.Gone, instance.Status), nil
+	}
+
+	return skprovider.NewVerdict(skprovider.Exists, instance.Status), nil
+}
+
+// Provider IDs look like gce://<project>/<zone>/<instance>
+func parseProviderID(providerID string) (string, string, string, error) {
+	// should have been checked already, being defensive
+	if !strings.HasPrefix(providerID, "gce://") {
+		return "", "", "", fmt.Errorf("providerID %s does not start with gce://", providerID)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(providerID, "gce://"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("providerID %s is not of the form gce://<project>/<zone>/<instance>", providerID)
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+type computeClient struct {
+	svc *compute.Service
+}
+
+func (c *computeClient) GetInstance(ctx context.Context, project, zone, name string) (*compute.Instance, error) {
+	return c.svc.Instances.Get(project, zone, name).Context(ctx).Do()
+}
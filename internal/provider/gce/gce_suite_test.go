@@ -0,0 +1,13 @@
+package gce_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestGce(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Gce Suite")
+}
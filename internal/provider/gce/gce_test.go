@@ -0,0 +1,113 @@
+package gce_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/gce"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	project      = "my-project"
+	zone         = "europe-west1-b"
+	runningID    = "node-running"
+	terminatedID = "node-terminated"
+	missingID    = "node-missing"
+	errorID      = "node-error"
+)
+
+var _ = Describe("GCE Provider", func() {
+	var (
+		provider *gce.Provider
+	)
+
+	BeforeEach(func() {
+		provider = &gce.Provider{
+			Client: &MockComputeClient{
+				instances: []*compute.Instance{
+					{
+						Name:   runningID,
+						Status: "RUNNING",
+					},
+					{
+						Name:   terminatedID,
+						Status: "TERMINATED",
+					},
+				},
+			},
+		}
+	})
+
+	Describe("Checking if a GCE instance exists", func() {
+		It("should be true for running instances", func() {
+			providerID := fmt.Sprintf("gce://%s/%s/%s", project, zone, runningID)
+			verdict, err := provider.InstanceVerdict(providerID)
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+		})
+
+		It("should not be true for terminated instances", func() {
+			providerID := fmt.Sprintf("gce://%s/%s/%s", project, zone, terminatedID)
+			verdict, err := provider.InstanceVerdict(providerID)
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Gone))
+		})
+
+		It("should not be true when the instance was not found", func() {
+			providerID := fmt.Sprintf("gce://%s/%s/%s", project, zone, missingID)
+			verdict, err := provider.InstanceVerdict(providerID)
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Gone))
+		})
+
+		It("should propagate errors", func() {
+			providerID := fmt.Sprintf("gce://%s/%s/%s", project, zone, errorID)
+			_, err := provider.InstanceVerdict(providerID)
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject malformed provider IDs", func() {
+			_, err := provider.InstanceVerdict(fmt.Sprintf("gce://%s/%s", project, runningID))
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+type MockComputeClient struct {
+	instances []*compute.Instance
+}
+
+func (c *MockComputeClient) GetInstance(ctx context.Context, p, z, name string) (*compute.Instance, error) {
+	if name == errorID {
+		return nil, &googleapi.Error{
+			Code:    http.StatusForbidden,
+			Message: "Mock error",
+		}
+	}
+
+	if p == project && z == zone {
+		for _, instance := range c.instances {
+			if instance.Name == name {
+				return instance, nil
+			}
+		}
+	}
+
+	return nil, &googleapi.Error{
+		Code:    http.StatusNotFound,
+		Message: "Mock instance not found error",
+	}
+}
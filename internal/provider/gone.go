@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateGoneStates checks every state which is configured to count as gone
+// is one of the provider's known states, so a typo doesn't silently match
+// nothing
+func ValidateGoneStates(states, known []string) error {
+	for _, state := range states {
+		if !containsState(known, state) {
+			return fmt.Errorf("unknown state %q, expected one of %s", state, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// IsGone checks whether an instance in a state, in the provider's own terms,
+// counts as gone, going by defaults if no gone states are configured
+func IsGone(state string, goneStates, defaults []string) bool {
+	if len(goneStates) == 0 {
+		goneStates = defaults
+	}
+	return containsState(goneStates, state)
+}
+
+// GoneVerdict describes an instance the provider has just seen in its own
+// providerState, which is gone if it is one of goneStates
+func GoneVerdict(providerState string, goneStates, defaults []string) Verdict {
+	if IsGone(providerState, goneStates, defaults) {
+		return NewVerdict(Gone, providerState)
+	}
+	return NewVerdict(Exists, providerState)
+}
+
+// Providers aren't consistent about case, so neither are their states
+func containsState(states []string, state string) bool {
+	for _, s := range states {
+		if strings.EqualFold(s, state) {
+			return true
+		}
+	}
+	return false
+}
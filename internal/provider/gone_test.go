@@ -0,0 +1,45 @@
+package provider_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vixus0/skuttle/v2/internal/provider"
+)
+
+var _ = Describe("Gone states", func() {
+	var (
+		known    = []string{"running", "stopped", "terminated", "missing"}
+		defaults = []string{"terminated", "missing"}
+	)
+
+	It("should accept known states, ignoring case", func() {
+		Expect(provider.ValidateGoneStates([]string{"stopped", "Missing"}, known)).To(Succeed())
+		Expect(provider.ValidateGoneStates(nil, known)).To(Succeed())
+	})
+
+	It("should reject unknown states", func() {
+		err := provider.ValidateGoneStates([]string{"stopped", "stoped"}, known)
+
+		Expect(err).To(MatchError(ContainSubstring(`unknown state "stoped"`)))
+	})
+
+	It("should go by the defaults unless gone states are configured", func() {
+		Expect(provider.IsGone("terminated", nil, defaults)).To(BeTrue())
+		Expect(provider.IsGone("stopped", nil, defaults)).To(BeFalse())
+
+		Expect(provider.IsGone("terminated", []string{"stopped"}, defaults)).To(BeFalse())
+		Expect(provider.IsGone("Stopped", []string{"stopped"}, defaults)).To(BeTrue())
+	})
+
+	It("should give a verdict in the provider's own terms", func() {
+		verdict := provider.GoneVerdict("stopped", nil, defaults)
+
+		Expect(verdict.State).To(Equal(provider.Exists))
+		Expect(verdict.ProviderState).To(Equal("stopped"))
+
+		verdict = provider.GoneVerdict("missing", nil, defaults)
+
+		Expect(verdict.State).To(Equal(provider.Gone))
+	})
+})
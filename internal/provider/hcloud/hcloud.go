@@ -0,0 +1,113 @@
+package hcloud
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	"github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/hcloud")
+)
+
+// StatusMissing is the status of servers hcloud doesn't know about
+const StatusMissing = "missing"
+
+// KnownStates are the server statuses, and missing
+var KnownStates = []string{
+	string(hcloud.ServerStatusInitializing),
+	string(hcloud.ServerStatusStarting),
+	string(hcloud.ServerStatusRunning),
+	string(hcloud.ServerStatusStopping),
+	string(hcloud.ServerStatusOff),
+	string(hcloud.ServerStatusDeleting),
+	string(hcloud.ServerStatusMigrating),
+	string(hcloud.ServerStatusRebuilding),
+	string(hcloud.ServerStatusUnknown),
+	StatusMissing,
+}
+
+// DefaultGoneStates are the server statuses which count as gone unless
+// configured otherwise, so servers switched off for maintenance are kept
+var DefaultGoneStates = []string{
+	string(hcloud.ServerStatusDeleting),
+	StatusMissing,
+}
+
+// ServerAPIClient is the part of the hcloud server client we use
+type ServerAPIClient interface {
+	GetByID(ctx context.Context, id int) (*hcloud.Server, *hcloud.Response, error)
+}
+
+type Config struct {
+	// Server statuses which count as gone, defaults to DefaultGoneStates
+	GoneStates []string
+}
+
+type Provider struct {
+	Client     ServerAPIClient
+	GoneStates []string
+}
+
+func NewProvider(cfg *Config) (*Provider, error) {
+	if err := skprovider.ValidateGoneStates(cfg.GoneStates, KnownStates); err != nil {
+		return nil, fmt.Errorf("invalid gone states, %v", err)
+	}
+
+	token, ok := os.LookupEnv("HCLOUD_TOKEN")
+	if !ok {
+		return nil, fmt.Errorf("Need to specify an API token in HCLOUD_TOKEN")
+	}
+
+	opts := []hcloud.ClientOption{
+		hcloud.WithToken(token),
+		hcloud.WithApplication("skuttle", ""),
+	}
+
+	if endpoint, ok := os.LookupEnv("HCLOUD_ENDPOINT"); ok {
+		opts = append(opts, hcloud.WithEndpoint(endpoint))
+	}
+
+	client := hcloud.NewClient(opts...)
+
+	return &Provider{
+		Client:     &client.Server,
+		GoneStates: cfg.GoneStates,
+	}, nil
+}
+
+// InstanceVerdict reports the server's status along with whether it counts
+// as gone
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	// should have been checked already, being defensive
+	if !strings.HasPrefix(providerID, "hcloud://") {
+		return skprovider.Verdict{}, fmt.Errorf("providerID %s does not start with hcloud://", providerID)
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(providerID, "hcloud://"))
+	if err != nil {
+		return skprovider.Verdict{}, fmt.Errorf("providerID %s does not have a numeric server ID", providerID)
+	}
+
+	server, _, err := provider.Client.GetByID(context.TODO(), id)
+	if err != nil {
+		return skprovider.Verdict{}, fmt.Errorf("failed to get server %d, %v", id, err)
+	}
+
+	// hcloud returns no server rather than an error when it's not found
+	if server == nil {
+		log.Info("no server found for ID %d", id)
+		return skprovider.GoneVerdict(StatusMissing, provider.GoneStates, DefaultGoneStates), nil
+	}
+
+	log.Debug("server %d is %s", id, server.Status)
+
+	return skprovider.GoneVerdict(string(server.Status), provider.GoneStates, DefaultGoneStates), nil
+}
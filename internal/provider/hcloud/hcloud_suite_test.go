@@ -0,0 +1,13 @@
+package hcloud_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestHcloud(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Hcloud Suite")
+}
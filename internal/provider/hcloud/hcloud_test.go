@@ -0,0 +1,116 @@
+package hcloud_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/hcloud"
+
+	hcloudsdk "github.com/hetznercloud/hcloud-go/hcloud"
+)
+
+var _ = Describe("Hetzner Cloud provider", func() {
+	var (
+		server   *httptest.Server
+		provider *hcloud.Provider
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(&MockHcloud{
+			Servers: map[string]string{
+				"1": "running",
+				"2": "starting",
+				"3": "off",
+				"4": "deleting",
+			},
+		})
+
+		client := hcloudsdk.NewClient(
+			hcloudsdk.WithEndpoint(server.URL),
+			hcloudsdk.WithToken("token"),
+		)
+
+		provider = &hcloud.Provider{
+			Client: &client.Server,
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Checking a server exists", func() {
+		It("should be true for running servers", func() {
+			Expect(skprovider.InstanceExists(provider, "hcloud://1")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "hcloud://2")).To(BeTrue())
+		})
+
+		It("should be true for servers which are off, which can be started again", func() {
+			Expect(skprovider.InstanceExists(provider, "hcloud://3")).To(BeTrue())
+		})
+
+		It("should be false for servers being deleted", func() {
+			Expect(skprovider.InstanceExists(provider, "hcloud://4")).To(BeFalse())
+		})
+
+		It("should follow the configured gone states", func() {
+			provider.GoneStates = []string{"off"}
+
+			Expect(skprovider.InstanceExists(provider, "hcloud://3")).To(BeFalse())
+			Expect(skprovider.InstanceExists(provider, "hcloud://4")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "hcloud://5")).To(BeTrue())
+		})
+
+		It("should reject unknown gone states", func() {
+			_, err := hcloud.NewProvider(&hcloud.Config{GoneStates: []string{"of"}})
+
+			Expect(err).To(MatchError(ContainSubstring(`unknown state "of"`)))
+		})
+
+		It("should be false for missing servers", func() {
+			Expect(skprovider.InstanceExists(provider, "hcloud://5")).To(BeFalse())
+		})
+
+		It("should propagate errors", func() {
+			_, err := provider.InstanceVerdict("hcloud://666")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject invalid provider IDs", func() {
+			_, err := provider.InstanceVerdict("hcloud://server")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+// MockHcloud serves server statuses from the Hetzner Cloud API
+type MockHcloud struct {
+	Servers map[string]string
+}
+
+func (m *MockHcloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := strings.TrimPrefix(r.URL.Path, "/servers/")
+
+	if id == "666" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"error": {"code": "unavailable", "message": "Mock error"}}`)
+		return
+	}
+
+	status, ok := m.Servers[id]
+	if r.Method != http.MethodGet || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": {"code": "not_found", "message": "Mock server not found"}}`)
+		return
+	}
+
+	fmt.Fprintf(w, `{"server": {"id": %s, "name": "server-%s", "status": "%s"}}`, id, id, status)
+}
@@ -0,0 +1,142 @@
+package karpenter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/karpenter")
+)
+
+// NodeClaimResource is the Karpenter NodeClaim resource
+var NodeClaimResource = schema.GroupVersionResource{
+	Group:    "karpenter.sh",
+	Version:  "v1",
+	Resource: "nodeclaims",
+}
+
+// ConditionLaunched is the NodeClaim condition reporting whether its
+// instance was launched, which turns False if the instance is terminated
+const ConditionLaunched = "Launched"
+
+type Provider struct {
+	Client dynamic.Interface
+}
+
+func NewProvider(restConfig *rest.Config) (*Provider, error) {
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client, %v", err)
+	}
+
+	return &Provider{
+		Client: client,
+	}, nil
+}
+
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	verdicts, err := provider.InstanceVerdicts([]string{providerID})
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	return verdicts[providerID], nil
+}
+
+// InstanceVerdicts checks several instances against a single list of
+// NodeClaims. NodeClaims being deleted still count, since Karpenter is
+// already taking care of them.
+func (provider *Provider) InstanceVerdicts(providerIDs []string) (map[string]skprovider.Verdict, error) {
+	list, err := provider.Client.Resource(NodeClaimResource).List(context.TODO(), metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		return nil, fmt.Errorf("not allowed to list nodeclaims, check skuttle's RBAC rules, %v", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodeclaims, %v", err)
+	}
+
+	nodeClaims := map[string]*unstructured.Unstructured{}
+	for i := range list.Items {
+		nodeClaim := &list.Items[i]
+		providerID, _, _ := unstructured.NestedString(nodeClaim.Object, "status", "providerID")
+		if providerID != "" {
+			nodeClaims[providerID] = nodeClaim
+		}
+	}
+
+	verdicts := map[string]skprovider.Verdict{}
+
+	// A missing NodeClaim only means the instance is gone if skuttle can
+	// see the cluster's NodeClaims at all
+	if len(nodeClaims) == 0 {
+		log.Warn("no nodeclaims found")
+		for _, providerID := range providerIDs {
+			verdicts[providerID] = skprovider.Verdict{
+				State:      skprovider.Unknown,
+				Reason:     "no nodeclaims found",
+				ObservedAt: time.Now(),
+			}
+		}
+		return verdicts, nil
+	}
+
+	for _, providerID := range providerIDs {
+		nodeClaim, ok := nodeClaims[providerID]
+		if !ok {
+			log.Info("no nodeclaim found for %s", providerID)
+			verdicts[providerID] = skprovider.Verdict{
+				State:      skprovider.Gone,
+				Reason:     "no nodeclaim has this provider ID",
+				ObservedAt: time.Now(),
+			}
+			continue
+		}
+
+		if status, reason := condition(nodeClaim, ConditionLaunched); status == "False" {
+			log.Info("nodeclaim %s for %s is not launched: %s", nodeClaim.GetName(), providerID, reason)
+			verdicts[providerID] = skprovider.Verdict{
+				State:         skprovider.Gone,
+				Reason:        fmt.Sprintf("nodeclaim is not launched: %s", reason),
+				ProviderState: "NotLaunched",
+				ObservedAt:    time.Now(),
+			}
+			continue
+		}
+
+		log.Debug("nodeclaim %s for %s is launched", nodeClaim.GetName(), providerID)
+		verdicts[providerID] = skprovider.NewVerdict(skprovider.Exists, "Launched")
+	}
+
+	return verdicts, nil
+}
+
+// condition returns the status and reason of a NodeClaim condition, or
+// empty strings if it isn't set
+func condition(nodeClaim *unstructured.Unstructured, conditionType string) (string, string) {
+	conditions, _, _ := unstructured.NestedSlice(nodeClaim.Object, "status", "conditions")
+
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != conditionType {
+			continue
+		}
+
+		status, _ := cond["status"].(string)
+		reason, _ := cond["reason"].(string)
+		return status, reason
+	}
+
+	return "", ""
+}
@@ -0,0 +1,134 @@
+package karpenter_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/karpenter"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Karpenter provider", func() {
+	var (
+		provider *karpenter.Provider
+	)
+
+	BeforeEach(func() {
+		deleting := NodeClaim("deleting", "aws:///us-east-1a/i-deleting", "True")
+		now := metav1.Now()
+		deleting.SetDeletionTimestamp(&now)
+
+		provider = &karpenter.Provider{
+			Client: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+				runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{
+					karpenter.NodeClaimResource: "NodeClaimList",
+				},
+				NodeClaim("launched", "aws:///us-east-1a/i-launched", "True"),
+				NodeClaim("launching", "aws:///us-east-1a/i-launching", "Unknown"),
+				NodeClaim("terminated", "aws:///us-east-1a/i-terminated", "False"),
+				deleting,
+			),
+		}
+	})
+
+	Describe("Checking an instance exists", func() {
+		It("should be true for launched nodeclaims", func() {
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-launched")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-launching")).To(BeTrue())
+		})
+
+		It("should be true for nodeclaims Karpenter is deleting", func() {
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-deleting")).To(BeTrue())
+		})
+
+		It("should be false when the launch reports termination", func() {
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-terminated")).To(BeFalse())
+		})
+
+		It("should be false without a nodeclaim", func() {
+			Expect(skprovider.InstanceExists(provider, "aws:///us-east-1a/i-missing")).To(BeFalse())
+		})
+
+		It("should check several instances at once", func() {
+			verdicts, err := provider.InstanceVerdicts([]string{
+				"aws:///us-east-1a/i-launched",
+				"aws:///us-east-1a/i-terminated",
+				"aws:///us-east-1a/i-missing",
+			})
+
+			Expect(err).To(BeNil())
+			Expect(States(verdicts)).To(Equal(map[string]skprovider.State{
+				"aws:///us-east-1a/i-launched":   skprovider.Exists,
+				"aws:///us-east-1a/i-terminated": skprovider.Gone,
+				"aws:///us-east-1a/i-missing":    skprovider.Gone,
+			}))
+		})
+	})
+
+	Describe("Failing to see nodeclaims", func() {
+		It("should not know without any nodeclaims", func() {
+			provider.Client = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+				runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{
+					karpenter.NodeClaimResource: "NodeClaimList",
+				},
+			)
+			verdict, err := provider.InstanceVerdict("aws:///us-east-1a/i-missing")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Unknown))
+		})
+
+		It("should return an error when not allowed to list nodeclaims", func() {
+			client := provider.Client.(*dynamicfake.FakeDynamicClient)
+			client.PrependReactor("list", "nodeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(karpenter.NodeClaimResource.GroupResource(), "", fmt.Errorf("rbac"))
+			})
+			_, err := provider.InstanceVerdict("aws:///us-east-1a/i-missing")
+
+			Expect(err).To(MatchError(ContainSubstring("RBAC")))
+		})
+	})
+})
+
+func NodeClaim(name, providerID, launched string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "karpenter.sh/v1",
+			"kind":       "NodeClaim",
+			"metadata": map[string]interface{}{
+				"name": name,
+			},
+			"status": map[string]interface{}{
+				"providerID": providerID,
+				"conditions": []interface{}{
+					map[string]interface{}{
+						"type":   karpenter.ConditionLaunched,
+						"status": launched,
+						"reason": "Mock",
+					},
+				},
+			},
+		},
+	}
+}
+
+// States picks the state out of each verdict
+func States(verdicts map[string]skprovider.Verdict) map[string]skprovider.State {
+	states := map[string]skprovider.State{}
+	for id, verdict := range verdicts {
+		states[id] = verdict.State
+	}
+	return states
+}
@@ -0,0 +1,155 @@
+package kubevirt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/kubevirt")
+)
+
+var (
+	// VirtualMachineInstanceResource is a running KubeVirt VM
+	VirtualMachineInstanceResource = schema.GroupVersionResource{
+		Group:    "kubevirt.io",
+		Version:  "v1",
+		Resource: "virtualmachineinstances",
+	}
+
+	// VirtualMachineResource is a KubeVirt VM which may be stopped
+	VirtualMachineResource = schema.GroupVersionResource{
+		Group:    "kubevirt.io",
+		Version:  "v1",
+		Resource: "virtualmachines",
+	}
+)
+
+// VirtualMachineInstance phases after which it won't run again
+const (
+	PhaseSucceeded = "Succeeded"
+	PhaseFailed    = "Failed"
+)
+
+// States of instances without a running VirtualMachineInstance
+const (
+	StateStopped = "Stopped"
+	StateMissing = "missing"
+)
+
+type Config struct {
+	// Path to a kubeconfig for the infrastructure cluster, or in-cluster
+	// config if empty
+	Kubeconfig string
+	// Namespace of the VMs, unless the provider ID includes one
+	Namespace string
+}
+
+type Provider struct {
+	Client    dynamic.Interface
+	Namespace string
+}
+
+func NewProvider(cfg *Config) (*Provider, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", cfg.Kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load infra cluster kubeconfig, %v", err)
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client, %v", err)
+	}
+
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	log.Info("looking for VMs at %s in namespace %s", restConfig.Host, namespace)
+
+	return &Provider{
+		Client:    client,
+		Namespace: namespace,
+	}, nil
+}
+
+// InstanceVerdict reports an instance as gone once its VM has been deleted
+// and its last instance has finished or gone too. Stopped VMs still exist,
+// since they can be started again.
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	namespace, name, err := provider.parse(providerID)
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	vmi, err := provider.get(VirtualMachineInstanceResource, namespace, name)
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	if vmi != nil {
+		phase, _, _ := unstructured.NestedString(vmi.Object, "status", "phase")
+		log.Debug("vmi %s/%s is %s", namespace, name, phase)
+
+		if phase != PhaseSucceeded && phase != PhaseFailed {
+			return skprovider.NewVerdict(skprovider.Exists, phase), nil
+		}
+	}
+
+	vm, err := provider.get(VirtualMachineResource, namespace, name)
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	if vm != nil && vm.GetDeletionTimestamp() == nil {
+		log.Debug("vm %s/%s exists", namespace, name)
+		return skprovider.NewVerdict(skprovider.Exists, StateStopped), nil
+	}
+
+	log.Info("no vm or running vmi found for %s/%s", namespace, name)
+	return skprovider.NewVerdict(skprovider.Gone, StateMissing), nil
+}
+
+// parse splits kubevirt://<name> or kubevirt://<namespace>/<name>
+func (provider *Provider) parse(providerID string) (string, string, error) {
+	// should have been checked already, being defensive
+	if !strings.HasPrefix(providerID, "kubevirt://") {
+		return "", "", fmt.Errorf("providerID %s does not start with kubevirt://", providerID)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(providerID, "kubevirt://"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return provider.Namespace, parts[0], nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return parts[0], parts[1], nil
+	default:
+		return "", "", fmt.Errorf("providerID %s should be kubevirt://<name> or kubevirt://<namespace>/<name>", providerID)
+	}
+}
+
+// get returns an object, or nil if it doesn't exist
+func (provider *Provider) get(resource schema.GroupVersionResource, namespace, name string) (*unstructured.Unstructured, error) {
+	obj, err := provider.Client.Resource(resource).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s %s/%s, %v", resource.Resource, namespace, name, err)
+	}
+
+	return obj, nil
+}
@@ -0,0 +1,13 @@
+package kubevirt_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestKubevirt(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Kubevirt Suite")
+}
@@ -0,0 +1,102 @@
+package kubevirt_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/kubevirt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+var _ = Describe("KubeVirt provider", func() {
+	var (
+		provider *kubevirt.Provider
+	)
+
+	BeforeEach(func() {
+		deleting := Object("VirtualMachine", "tenant", "vm-deleting", "")
+		now := metav1.Now()
+		deleting.SetDeletionTimestamp(&now)
+
+		provider = &kubevirt.Provider{
+			Client: dynamicfake.NewSimpleDynamicClient(
+				runtime.NewScheme(),
+				// A VM with a running instance
+				Object("VirtualMachine", "tenant", "vm-running", ""),
+				Object("VirtualMachineInstance", "tenant", "vm-running", "Running"),
+				// A stopped VM
+				Object("VirtualMachine", "tenant", "vm-stopped", ""),
+				// A deleted VM whose instance is still running
+				Object("VirtualMachineInstance", "tenant", "vmi-orphan", "Running"),
+				// A deleted VM whose instance has finished
+				Object("VirtualMachineInstance", "tenant", "vmi-failed", "Failed"),
+				// A VM being deleted
+				deleting,
+				Object("VirtualMachine", "other", "vm-other", ""),
+			),
+			Namespace: "tenant",
+		}
+	})
+
+	Describe("Checking an instance exists", func() {
+		It("should be true for running instances", func() {
+			Expect(skprovider.InstanceExists(provider, "kubevirt://vm-running")).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "kubevirt://vmi-orphan")).To(BeTrue())
+		})
+
+		It("should be true for stopped VMs", func() {
+			Expect(skprovider.InstanceExists(provider, "kubevirt://vm-stopped")).To(BeTrue())
+		})
+
+		It("should be false for finished instances without a VM", func() {
+			Expect(skprovider.InstanceExists(provider, "kubevirt://vmi-failed")).To(BeFalse())
+		})
+
+		It("should be false for VMs being deleted", func() {
+			Expect(skprovider.InstanceExists(provider, "kubevirt://vm-deleting")).To(BeFalse())
+		})
+
+		It("should be false without a VM or instance", func() {
+			Expect(skprovider.InstanceExists(provider, "kubevirt://vm-missing")).To(BeFalse())
+		})
+
+		It("should use the namespace in the provider ID", func() {
+			Expect(skprovider.InstanceExists(provider, "kubevirt://vm-other")).To(BeFalse())
+			Expect(skprovider.InstanceExists(provider, "kubevirt://other/vm-other")).To(BeTrue())
+		})
+
+		It("should reject invalid provider IDs", func() {
+			_, err := provider.InstanceVerdict("kubevirt://a/b/c")
+			Expect(err).To(HaveOccurred())
+
+			_, err = provider.InstanceVerdict("kubevirt://")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+func Object(kind, namespace, name, phase string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "kubevirt.io/v1",
+			"kind":       kind,
+			"metadata": map[string]interface{}{
+				"namespace": namespace,
+				"name":      name,
+			},
+		},
+	}
+
+	if phase != "" {
+		obj.Object["status"] = map[string]interface{}{
+			"phase": phase,
+		}
+	}
+
+	return obj
+}
@@ -0,0 +1,222 @@
+package libvirt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/digitalocean/go-libvirt/socket"
+	"github.com/digitalocean/go-libvirt/socket/dialers"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/libvirt")
+)
+
+type State string
+
+const (
+	Running   State = "running"
+	Blocked   State = "blocked"
+	Paused    State = "paused"
+	Shutdown  State = "shutdown"
+	Shutoff   State = "shutoff"
+	Crashed   State = "crashed"
+	Suspended State = "suspended"
+	// The host has no such domain
+	Undefined State = "undefined"
+	// The host has the domain but doesn't know its state
+	Unknown State = "unknown"
+)
+
+// Conn is a connection to a libvirt host
+type Conn interface {
+	// DomainState returns the state of a domain, or Undefined if the host
+	// doesn't have it
+	DomainState(uuid libvirt.UUID) (State, error)
+}
+
+type Provider struct {
+	// Hosts to look for domains on
+	Conns []Conn
+}
+
+func NewProvider(uris []string, timeout time.Duration) (*Provider, error) {
+	var conns []Conn
+
+	for _, uri := range uris {
+		conn, err := NewConn(uri, timeout)
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("Need at least one libvirt URI")
+	}
+
+	return &Provider{
+		Conns: conns,
+	}, nil
+}
+
+// InstanceVerdict reports the domain as existing if any host has it and it
+// isn't shut off or crashed
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	// should have been checked already, being defensive
+	if !strings.HasPrefix(providerID, "libvirt://") {
+		return skprovider.Verdict{}, fmt.Errorf("providerID %s does not start with libvirt://", providerID)
+	}
+
+	id := strings.TrimLeft(strings.TrimPrefix(providerID, "libvirt://"), "/")
+	uuid, err := ParseUUID(id)
+	if err != nil {
+		return skprovider.Verdict{}, fmt.Errorf("providerID %s does not have a domain UUID, %v", providerID, err)
+	}
+
+	last := Undefined
+	for _, conn := range provider.Conns {
+		state, err := conn.DomainState(uuid)
+		if err != nil {
+			return skprovider.Verdict{}, err
+		}
+
+		log.Debug("domain %s is %s on %s", id, state, conn)
+
+		switch state {
+		case Undefined:
+			continue
+		case Shutoff, Crashed:
+			last = state
+			continue
+		}
+
+		return skprovider.NewVerdict(skprovider.Exists, string(state)), nil
+	}
+
+	log.Info("no running domain found for %s", id)
+	return skprovider.NewVerdict(skprovider.Gone, string(last)), nil
+}
+
+// ParseUUID parses a domain UUID, with or without dashes
+func ParseUUID(s string) (libvirt.UUID, error) {
+	var uuid libvirt.UUID
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil {
+		return uuid, err
+	}
+	if len(b) != len(uuid) {
+		return uuid, fmt.Errorf("%s is not a UUID", s)
+	}
+
+	copy(uuid[:], b)
+	return uuid, nil
+}
+
+// hostConn connects to a libvirt host for each lookup
+type hostConn struct {
+	uri    string
+	driver libvirt.ConnectURI
+	dialer socket.Dialer
+}
+
+// NewConn creates a connection to a host from a libvirt URI, like
+// qemu:///system or qemu+tcp://host/system. Only the unix and tcp
+// transports are supported.
+func NewConn(uri string, timeout time.Duration) (Conn, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid libvirt URI %s, %v", uri, err)
+	}
+
+	parts := strings.SplitN(u.Scheme, "+", 2)
+	driver := libvirt.ConnectURI(fmt.Sprintf("%s://%s", parts[0], u.Path))
+
+	transport := "unix"
+	if len(parts) == 2 {
+		transport = parts[1]
+	} else if u.Host != "" {
+		// libvirt defaults to TLS for remote hosts
+		transport = "tls"
+	}
+
+	var dialer socket.Dialer
+
+	switch transport {
+	case "unix":
+		opts := []dialers.LocalOption{dialers.WithLocalTimeout(timeout)}
+		if path := u.Query().Get("socket"); path != "" {
+			opts = append(opts, dialers.WithSocket(path))
+		}
+		dialer = dialers.NewLocal(opts...)
+	case "tcp":
+		opts := []dialers.RemoteOption{dialers.WithRemoteTimeout(timeout)}
+		if port := u.Port(); port != "" {
+			opts = append(opts, dialers.UsePort(port))
+		}
+		dialer = dialers.NewRemote(u.Hostname(), opts...)
+	default:
+		return nil, fmt.Errorf("unsupported transport %s in libvirt URI %s", transport, uri)
+	}
+
+	return &hostConn{
+		uri:    uri,
+		driver: driver,
+		dialer: dialer,
+	}, nil
+}
+
+func (c *hostConn) String() string {
+	return c.uri
+}
+
+func (c *hostConn) DomainState(uuid libvirt.UUID) (State, error) {
+	l := libvirt.NewWithDialer(c.dialer)
+	if err := l.ConnectToURI(c.driver); err != nil {
+		return "", fmt.Errorf("failed to connect to %s, %v", c.uri, err)
+	}
+	defer l.Disconnect()
+
+	domain, err := l.DomainLookupByUUID(uuid)
+	if err != nil {
+		if libvirt.IsNotFound(err) {
+			return Undefined, nil
+		}
+		return "", fmt.Errorf("failed to look up domain on %s, %v", c.uri, err)
+	}
+
+	state, _, err := l.DomainGetState(domain, 0)
+	if err != nil {
+		if libvirt.IsNotFound(err) {
+			return Undefined, nil
+		}
+		return "", fmt.Errorf("failed to get domain state on %s, %v", c.uri, err)
+	}
+
+	switch libvirt.DomainState(state) {
+	case libvirt.DomainRunning:
+		return Running, nil
+	case libvirt.DomainBlocked:
+		return Blocked, nil
+	case libvirt.DomainPaused:
+		return Paused, nil
+	case libvirt.DomainShutdown:
+		return Shutdown, nil
+	case libvirt.DomainShutoff:
+		return Shutoff, nil
+	case libvirt.DomainCrashed:
+		return Crashed, nil
+	case libvirt.DomainPmsuspended:
+		return Suspended, nil
+	default:
+		return Unknown, nil
+	}
+}
@@ -0,0 +1,136 @@
+package libvirt_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/libvirt"
+
+	libvirtsdk "github.com/digitalocean/go-libvirt"
+)
+
+const (
+	runningID   = "6b7c3c8e-0000-4000-8000-000000000001"
+	shutoffID   = "6b7c3c8e-0000-4000-8000-000000000002"
+	crashedID   = "6b7c3c8e-0000-4000-8000-000000000003"
+	pausedID    = "6b7c3c8e-0000-4000-8000-000000000004"
+	migratedID  = "6b7c3c8e-0000-4000-8000-000000000005"
+	undefinedID = "6b7c3c8e-0000-4000-8000-000000000006"
+	errorID     = "6b7c3c8e-0000-4000-8000-000000000007"
+)
+
+var _ = Describe("Libvirt provider", func() {
+	var (
+		provider *libvirt.Provider
+	)
+
+	BeforeEach(func() {
+		provider = &libvirt.Provider{
+			Conns: []libvirt.Conn{
+				FakeConn{
+					runningID: libvirt.Running,
+					shutoffID: libvirt.Shutoff,
+					crashedID: libvirt.Crashed,
+					pausedID:  libvirt.Paused,
+					// The domain was left behind shut off on its old host
+					migratedID: libvirt.Shutoff,
+				},
+				FakeConn{
+					migratedID: libvirt.Running,
+				},
+			},
+		}
+	})
+
+	Describe("Checking a domain exists", func() {
+		It("should be true for domains which are up", func() {
+			Expect(skprovider.InstanceExists(provider, "libvirt:///"+runningID)).To(BeTrue())
+			Expect(skprovider.InstanceExists(provider, "libvirt:///"+pausedID)).To(BeTrue())
+		})
+
+		It("should be false for domains which are shut off or crashed", func() {
+			Expect(skprovider.InstanceExists(provider, "libvirt:///"+shutoffID)).To(BeFalse())
+			Expect(skprovider.InstanceExists(provider, "libvirt:///"+crashedID)).To(BeFalse())
+		})
+
+		It("should be false for undefined domains", func() {
+			Expect(skprovider.InstanceExists(provider, "libvirt:///"+undefinedID)).To(BeFalse())
+		})
+
+		It("should look on every host", func() {
+			Expect(skprovider.InstanceExists(provider, "libvirt:///"+migratedID)).To(BeTrue())
+		})
+
+		It("should propagate errors", func() {
+			_, err := provider.InstanceVerdict("libvirt:///" + errorID)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should reject invalid provider IDs", func() {
+			_, err := provider.InstanceVerdict("libvirt:///domain")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Parsing UUIDs", func() {
+		It("should accept UUIDs with or without dashes", func() {
+			withDashes, err := libvirt.ParseUUID(runningID)
+			Expect(err).To(BeNil())
+
+			withoutDashes, err := libvirt.ParseUUID("6b7c3c8e000040008000000000000001")
+			Expect(err).To(BeNil())
+			Expect(withoutDashes).To(Equal(withDashes))
+		})
+
+		It("should reject anything else", func() {
+			_, err := libvirt.ParseUUID("6b7c3c8e")
+			Expect(err).To(HaveOccurred())
+
+			_, err = libvirt.ParseUUID("not-a-uuid")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Connecting to hosts", func() {
+		It("should support the unix and tcp transports", func() {
+			for _, uri := range []string{
+				"qemu:///system",
+				"qemu+unix:///system?socket=/run/libvirt/libvirt-sock",
+				"qemu+tcp://host/system",
+				"qemu+tcp://host:16510/system",
+			} {
+				_, err := libvirt.NewConn(uri, time.Second)
+				Expect(err).To(BeNil(), uri)
+			}
+		})
+
+		It("should reject other transports", func() {
+			_, err := libvirt.NewConn("qemu+ssh://host/system", time.Second)
+			Expect(err).To(HaveOccurred())
+
+			_, err = libvirt.NewConn("qemu://host/system", time.Second)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+// FakeConn is a host with domain states by UUID
+type FakeConn map[string]libvirt.State
+
+func (c FakeConn) DomainState(uuid libvirtsdk.UUID) (libvirt.State, error) {
+	for id, state := range c {
+		if parsed, _ := libvirt.ParseUUID(id); parsed == uuid {
+			return state, nil
+		}
+	}
+
+	if parsed, _ := libvirt.ParseUUID(errorID); parsed == uuid {
+		return "", fmt.Errorf("mock error")
+	}
+
+	return libvirt.Undefined, nil
+}
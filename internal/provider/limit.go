@@ -0,0 +1,224 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider")
+
+	circuitOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "skuttle_provider_circuit_open",
+			Help: "Whether the circuit breaker for a provider prefix is open, so calls are refused.",
+		},
+		[]string{"prefix"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(circuitOpen)
+}
+
+// ErrCircuitOpen is returned instead of calling a provider which keeps failing
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type LimitConfig struct {
+	// Calls per second allowed, or unlimited if zero
+	QPS float64
+	// Calls allowed at once before QPS applies
+	Burst int
+	// Consecutive errors which open the circuit, or never if zero
+	FailureThreshold int
+	// How long the circuit stays open before letting a call through to
+	// see if the provider has recovered
+	OpenDuration time.Duration
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpened
+	circuitHalfOpen
+)
+
+// LimitedProvider rate limits calls to another provider, and stops calling
+// it for a while after consecutive errors
+type LimitedProvider struct {
+	LimitConfig
+	Provider Provider
+	// Used to label metrics and logs
+	Prefix string
+
+	limiter *rate.Limiter
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewLimitedProvider(p Provider, prefix string, cfg *LimitConfig) *LimitedProvider {
+	limit := rate.Inf
+	if cfg.QPS > 0 {
+		limit = rate.Limit(cfg.QPS)
+	}
+
+	burst := cfg.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	circuitOpen.WithLabelValues(prefix).Set(0)
+
+	return &LimitedProvider{
+		LimitConfig: *cfg,
+		Provider:    p,
+		Prefix:      prefix,
+		limiter:     rate.NewLimiter(limit, burst),
+	}
+}
+
+func (l *LimitedProvider) InstanceVerdict(providerID string) (Verdict, error) {
+	var verdict Verdict
+	err := l.call(func() error {
+		var err error
+		verdict, err = l.Provider.InstanceVerdict(providerID)
+		return err
+	})
+	return verdict, err
+}
+
+// InstanceVerdicts counts as a single call when the provider supports
+// batches, otherwise each instance is checked as a call of its own
+func (l *LimitedProvider) InstanceVerdicts(providerIDs []string) (map[string]Verdict, error) {
+	bp, ok := l.Provider.(BatchProvider)
+	if !ok {
+		verdicts := map[string]Verdict{}
+		for _, id := range providerIDs {
+			verdict, err := l.InstanceVerdict(id)
+			if err != nil {
+				return nil, err
+			}
+			verdicts[id] = verdict
+		}
+		return verdicts, nil
+	}
+
+	var verdicts map[string]Verdict
+	err := l.call(func() error {
+		var err error
+		verdicts, err = bp.InstanceVerdicts(providerIDs)
+		return err
+	})
+	return verdicts, err
+}
+
+// DeleteInstance passes the deletion on if the provider handles it
+func (l *LimitedProvider) DeleteInstance(providerID string) error {
+	deleter, ok := l.Provider.(InstanceDeleter)
+	if !ok {
+		return nil
+	}
+
+	return l.call(func() error {
+		return deleter.DeleteInstance(providerID)
+	})
+}
+
+// VolumeAttached passes the check on if the provider handles it
+func (l *LimitedProvider) VolumeAttached(providerID, volumeID string) (bool, error) {
+	if _, ok := l.Provider.(VolumeChecker); !ok {
+		return true, ErrUnsupported
+	}
+
+	attached := true
+	err := l.call(func() error {
+		var err error
+		attached, err = VolumeAttached(l.Provider, providerID, volumeID)
+		return err
+	})
+	return attached, err
+}
+
+func (l *LimitedProvider) call(fn func() error) error {
+	if err := l.allow(); err != nil {
+		return err
+	}
+
+	if err := l.limiter.Wait(context.TODO()); err != nil {
+		// Not the provider's fault, so only let another probe through
+		l.mu.Lock()
+		l.probing = false
+		l.mu.Unlock()
+		return err
+	}
+
+	err := fn()
+	l.record(err)
+	return err
+}
+
+// allow checks whether the circuit lets a call through, letting one call at
+// a time probe the provider once it has been open for OpenDuration
+func (l *LimitedProvider) allow() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch l.state {
+	case circuitOpened:
+		if time.Since(l.openedAt) < l.OpenDuration {
+			return ErrCircuitOpen
+		}
+		log.Info("circuit for %s is half-open, probing provider", l.Prefix)
+		l.state = circuitHalfOpen
+		l.probing = true
+		return nil
+	case circuitHalfOpen:
+		if l.probing {
+			return ErrCircuitOpen
+		}
+		l.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (l *LimitedProvider) record(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.probing = false
+
+	if err == nil {
+		if l.state != circuitClosed {
+			log.Info("circuit for %s is closed, provider recovered", l.Prefix)
+			circuitOpen.WithLabelValues(l.Prefix).Set(0)
+		}
+		l.state = circuitClosed
+		l.failures = 0
+		return
+	}
+
+	l.failures++
+
+	if l.state == circuitHalfOpen || (l.FailureThreshold > 0 && l.failures >= l.FailureThreshold) {
+		if l.state != circuitOpened {
+			log.Warn("circuit for %s is open after %d consecutive errors, last: %v", l.Prefix, l.failures, err)
+			circuitOpen.WithLabelValues(l.Prefix).Set(1)
+		}
+		l.state = circuitOpened
+		l.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,168 @@
+package provider_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vixus0/skuttle/v2/internal/provider"
+)
+
+var _ = Describe("Limited provider", func() {
+	var (
+		fake    *FakeProvider
+		limited *provider.LimitedProvider
+	)
+
+	BeforeEach(func() {
+		fake = &FakeProvider{
+			Nodes: map[string]bool{
+				"node-exists":  true,
+				"node-missing": false,
+			},
+		}
+
+		limited = provider.NewLimitedProvider(fake, "fake", &provider.LimitConfig{
+			FailureThreshold: 3,
+			OpenDuration:     50 * time.Millisecond,
+		})
+	})
+
+	Describe("Rate limiting", func() {
+		It("should allow a burst and then wait", func() {
+			limited = provider.NewLimitedProvider(fake, "fake", &provider.LimitConfig{
+				QPS:   20,
+				Burst: 2,
+			})
+
+			start := time.Now()
+			for i := 0; i < 4; i++ {
+				Expect(provider.InstanceExists(limited, "fake://node-exists")).To(BeTrue())
+			}
+
+			// Two calls in the burst, then two more at 50ms intervals
+			Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+			Expect(fake.Calls).To(Equal(4))
+		})
+
+		It("should not limit without a QPS", func() {
+			start := time.Now()
+			for i := 0; i < 100; i++ {
+				Expect(provider.InstanceExists(limited, "fake://node-exists")).To(BeTrue())
+			}
+
+			Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+		})
+
+		It("should limit each instance of a batch if the provider can't batch", func() {
+			limited = provider.NewLimitedProvider(fake, "fake", &provider.LimitConfig{
+				QPS:   20,
+				Burst: 2,
+			})
+
+			start := time.Now()
+			verdicts, err := limited.InstanceVerdicts([]string{
+				"fake://node-exists",
+				"fake://node-missing",
+				"fake://node-exists",
+				"fake://node-missing",
+			})
+
+			Expect(err).To(BeNil())
+			Expect(verdicts).To(HaveLen(2))
+			Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+			Expect(fake.Calls).To(Equal(4))
+		})
+
+		It("should count a batch as one call if the provider can batch", func() {
+			batch := &FakeBatchProvider{FakeProvider: *fake}
+			limited = provider.NewLimitedProvider(batch, "fake", &provider.LimitConfig{
+				QPS:   20,
+				Burst: 1,
+			})
+
+			start := time.Now()
+			for i := 0; i < 2; i++ {
+				_, err := limited.InstanceVerdicts([]string{"fake://node-exists", "fake://node-missing"})
+				Expect(err).To(BeNil())
+			}
+
+			Expect(time.Since(start)).To(BeNumerically("<", 90*time.Millisecond))
+			Expect(batch.Batches).To(Equal(2))
+		})
+	})
+
+	Describe("Circuit breaking", func() {
+		It("should pass answers through while the provider works", func() {
+			Expect(provider.InstanceExists(limited, "fake://node-exists")).To(BeTrue())
+			Expect(provider.InstanceExists(limited, "fake://node-missing")).To(BeFalse())
+		})
+
+		It("should open after consecutive errors", func() {
+			for i := 0; i < 3; i++ {
+				_, err := provider.InstanceExists(limited, "fake://node-error")
+				Expect(err).To(HaveOccurred())
+				Expect(err).ToNot(Equal(provider.ErrCircuitOpen))
+			}
+
+			_, err := provider.InstanceExists(limited, "fake://node-exists")
+			Expect(err).To(Equal(provider.ErrCircuitOpen))
+			Expect(fake.Calls).To(Equal(3))
+		})
+
+		It("should not count errors which are followed by success", func() {
+			for i := 0; i < 2; i++ {
+				provider.InstanceExists(limited, "fake://node-error")
+			}
+			Expect(provider.InstanceExists(limited, "fake://node-exists")).To(BeTrue())
+			for i := 0; i < 2; i++ {
+				provider.InstanceExists(limited, "fake://node-error")
+			}
+
+			Expect(provider.InstanceExists(limited, "fake://node-exists")).To(BeTrue())
+		})
+
+		It("should close again once a probe succeeds", func() {
+			for i := 0; i < 3; i++ {
+				provider.InstanceExists(limited, "fake://node-error")
+			}
+
+			time.Sleep(60 * time.Millisecond)
+			Expect(provider.InstanceExists(limited, "fake://node-exists")).To(BeTrue())
+			Expect(provider.InstanceExists(limited, "fake://node-missing")).To(BeFalse())
+		})
+
+		It("should open again straight away if a probe fails", func() {
+			for i := 0; i < 3; i++ {
+				provider.InstanceExists(limited, "fake://node-error")
+			}
+
+			time.Sleep(60 * time.Millisecond)
+			_, err := provider.InstanceExists(limited, "fake://node-error")
+			Expect(err).ToNot(Equal(provider.ErrCircuitOpen))
+
+			_, err = provider.InstanceExists(limited, "fake://node-exists")
+			Expect(err).To(Equal(provider.ErrCircuitOpen))
+		})
+
+		It("should never answer that an instance is missing while open", func() {
+			for i := 0; i < 3; i++ {
+				provider.InstanceExists(limited, "fake://node-error")
+			}
+
+			verdicts, err := limited.InstanceVerdicts([]string{"fake://node-missing"})
+			Expect(err).To(Equal(provider.ErrCircuitOpen))
+			Expect(verdicts).To(BeNil())
+		})
+	})
+
+	Describe("Checking volumes", func() {
+		It("should say the provider can't check volumes if it doesn't handle them", func() {
+			attached, err := limited.VolumeAttached("fake://node-missing", "vol-1")
+
+			Expect(err).To(Equal(provider.ErrUnsupported))
+			Expect(attached).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,97 @@
+package openstack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	"github.com/gophercloud/gophercloud"
+	"github.com/gophercloud/gophercloud/openstack/compute/v2/servers"
+	"github.com/gophercloud/utils/openstack/clientconfig"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/openstack")
+)
+
+// Server statuses which mean the server is not coming back
+var goneStatuses = []string{
+	"DELETED",
+	"SOFT_DELETED",
+	"SHELVED_OFFLOADED",
+}
+
+// StatusMissing is the status of servers Nova doesn't know about
+const StatusMissing = "MISSING"
+
+// ServersAPIClient is the part of the Nova API used by the provider
+type ServersAPIClient interface {
+	GetServer(id string) (*servers.Server, error)
+}
+
+type Provider struct {
+	Client ServersAPIClient
+}
+
+func NewProvider() (*Provider, error) {
+	// Reads clouds.yaml when OS_CLOUD is set, otherwise the OS_* variables
+	client, err := clientconfig.NewServiceClient("compute", &clientconfig.ClientOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute client, %v", err)
+	}
+
+	return &Provider{
+		Client: NewServersClient(client),
+	}, nil
+}
+
+// NewServersClient wraps a gophercloud compute service client
+func NewServersClient(client *gophercloud.ServiceClient) ServersAPIClient {
+	return &serversClient{client}
+}
+
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	// should have been checked already, being defensive
+	if !strings.HasPrefix(providerID, "openstack://") {
+		return skprovider.Verdict{}, fmt.Errorf("providerID %s does not start with openstack://", providerID)
+	}
+
+	// assume the server UUID is the last path segment of a provider ID
+	parts := strings.Split(providerID, "/")
+	serverID := parts[len(parts)-1]
+
+	server, err := provider.Client.GetServer(serverID)
+
+	// Deal with API errors
+	if err != nil {
+		var notFound gophercloud.ErrDefault404
+
+		if errors.As(err, &notFound) {
+			log.Info("no server found for server ID %s", serverID)
+			return skprovider.NewVerdict(skprovider.Gone, StatusMissing), nil
+		}
+
+		log.Error("openstack API error: %v", err)
+		return skprovider.Verdict{}, err
+	}
+
+	for _, status := range goneStatuses {
+		if server.Status == status {
+			log.Info("server %s has status %s", serverID, server.Status)
+			return skprovider.NewVerdict(skprovider.Gone, server.Status), nil
+		}
+	}
+
+	return skprovider.NewVerdict(skprovider.Exists, server.Status), nil
+}
+
+type serversClient struct {
+	client *gophercloud.ServiceClient
+}
+
+func (c *serversClient) GetServer(id string) (*servers.Server, error) {
+	return servers.Get(c.client, id).Extract()
+}
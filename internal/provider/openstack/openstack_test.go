@@ -0,0 +1,129 @@
+package openstack_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/openstack"
+
+	"github.com/gophercloud/gophercloud"
+)
+
+const (
+	activeID           = "7a0b0a3e-7c5e-4b4f-9c52-1d4e7f2a0001"
+	shutoffID          = "7a0b0a3e-7c5e-4b4f-9c52-1d4e7f2a0002"
+	deletedID          = "7a0b0a3e-7c5e-4b4f-9c52-1d4e7f2a0003"
+	softDeletedID      = "7a0b0a3e-7c5e-4b4f-9c52-1d4e7f2a0004"
+	shelvedOffloadedID = "7a0b0a3e-7c5e-4b4f-9c52-1d4e7f2a0005"
+	missingID          = "7a0b0a3e-7c5e-4b4f-9c52-1d4e7f2a0006"
+	errorID            = "7a0b0a3e-7c5e-4b4f-9c52-1d4e7f2a0007"
+)
+
+var _ = Describe("OpenStack Provider", func() {
+	var (
+		provider *openstack.Provider
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(&MockNova{
+			statuses: map[string]string{
+				activeID:           "ACTIVE",
+				shutoffID:          "SHUTOFF",
+				deletedID:          "DELETED",
+				softDeletedID:      "SOFT_DELETED",
+				shelvedOffloadedID: "SHELVED_OFFLOADED",
+			},
+		})
+
+		client := &gophercloud.ServiceClient{
+			ProviderClient: &gophercloud.ProviderClient{
+				TokenID:    "mock-token",
+				HTTPClient: *server.Client(),
+			},
+			Endpoint: server.URL + "/",
+		}
+
+		provider = &openstack.Provider{
+			Client: openstack.NewServersClient(client),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Checking if a Nova server exists", func() {
+		It("should be true for active servers", func() {
+			verdict, err := provider.InstanceVerdict(fmt.Sprintf("openstack:///%s", activeID))
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+		})
+
+		It("should be true for servers which are only shut off", func() {
+			verdict, err := provider.InstanceVerdict(fmt.Sprintf("openstack:///%s", shutoffID))
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+		})
+
+		It("should not be true for deleted or offloaded servers", func() {
+			for _, id := range []string{deletedID, softDeletedID, shelvedOffloadedID} {
+				verdict, err := provider.InstanceVerdict(fmt.Sprintf("openstack:///%s", id))
+
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Gone))
+			}
+		})
+
+		It("should not be true when the server was not found", func() {
+			verdict, err := provider.InstanceVerdict(fmt.Sprintf("openstack:///%s", missingID))
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Gone))
+		})
+
+		It("should propagate errors", func() {
+			_, err := provider.InstanceVerdict(fmt.Sprintf("openstack:///%s", errorID))
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+// MockNova answers GET /servers/<id> like the Nova API
+type MockNova struct {
+	statuses map[string]string
+}
+
+func (m *MockNova) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || r.Header.Get("X-Auth-Token") != "mock-token" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/servers/")
+
+	if id == errorID {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"computeFault": {"code": 500, "message": "Mock error"}}`)
+		return
+	}
+
+	status, ok := m.statuses[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"itemNotFound": {"code": 404, "message": "Instance could not be found"}}`)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"server": {"id": "%s", "name": "node", "status": "%s"}}`, id, status)
+}
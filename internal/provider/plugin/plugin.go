@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	pb "github.com/vixus0/skuttle/v2/internal/provider/plugin/proto"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/plugin")
+)
+
+// Provider asks an out of process plugin over gRPC for verdicts on instances
+type Provider struct {
+	Address string
+	Timeout time.Duration
+
+	conn   *grpc.ClientConn
+	client pb.ProviderClient
+	health healthpb.HealthClient
+}
+
+// NewProvider connects to a plugin at a gRPC target address, such as
+// unix:///var/run/skuttle/plugin.sock or localhost:9000. The connection is
+// made lazily, so the plugin doesn't need to be up yet.
+func NewProvider(address string, timeout time.Duration) (*Provider, error) {
+	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection to %s, %v", address, err)
+	}
+
+	return &Provider{
+		Address: address,
+		Timeout: timeout,
+		conn:    conn,
+		client:  pb.NewProviderClient(conn),
+		health:  healthpb.NewHealthClient(conn),
+	}, nil
+}
+
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	ctx, cancel := provider.context()
+	defer cancel()
+
+	resp, err := provider.client.InstanceExists(ctx, &pb.InstanceExistsRequest{
+		ProviderId: providerID,
+	})
+	if err != nil {
+		return skprovider.Verdict{}, fmt.Errorf("plugin %s failed to check %s: %v", provider.Address, providerID, err)
+	}
+
+	verdict, err := FromProto(resp.Verdict)
+	if err != nil {
+		return skprovider.Verdict{}, fmt.Errorf("plugin %s returned an invalid verdict for %s: %v", provider.Address, providerID, err)
+	}
+
+	if verdict.State == skprovider.Gone {
+		log.Info("plugin %s reports %s missing", provider.Address, providerID)
+	}
+
+	return verdict, nil
+}
+
+// InstanceVerdicts checks several instances in a single call
+func (provider *Provider) InstanceVerdicts(providerIDs []string) (map[string]skprovider.Verdict, error) {
+	ctx, cancel := provider.context()
+	defer cancel()
+
+	resp, err := provider.client.InstancesExist(ctx, &pb.InstancesExistRequest{
+		ProviderIds: providerIDs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed to check %d instances: %v", provider.Address, len(providerIDs), err)
+	}
+
+	verdicts := map[string]skprovider.Verdict{}
+	for _, id := range providerIDs {
+		pbVerdict, ok := resp.Verdicts[id]
+
+		// Don't take a missing answer to mean a missing instance
+		if !ok {
+			return nil, fmt.Errorf("plugin %s returned no answer for %s", provider.Address, id)
+		}
+
+		verdict, err := FromProto(pbVerdict)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s returned an invalid verdict for %s: %v", provider.Address, id, err)
+		}
+		verdicts[id] = verdict
+	}
+
+	return verdicts, nil
+}
+
+// FromProto converts a plugin's verdict. Without a state the verdict is
+// unknown, so an empty response never gets a node deleted.
+func FromProto(verdict *pb.Verdict) (skprovider.Verdict, error) {
+	if verdict == nil || verdict.State == pb.State_STATE_UNSPECIFIED {
+		return skprovider.Verdict{
+			State:      skprovider.Unknown,
+			Reason:     "plugin gave no verdict",
+			ObservedAt: time.Now(),
+		}, nil
+	}
+
+	result := skprovider.Verdict{
+		Reason:        verdict.Reason,
+		ProviderState: verdict.ProviderState,
+		ObservedAt:    time.Now(),
+	}
+	if verdict.ObservedAt != nil {
+		result.ObservedAt = verdict.ObservedAt.AsTime()
+	}
+
+	switch verdict.State {
+	case pb.State_STATE_EXISTS:
+		result.State = skprovider.Exists
+	case pb.State_STATE_GONE:
+		result.State = skprovider.Gone
+	case pb.State_STATE_UNKNOWN:
+		result.State = skprovider.Unknown
+	default:
+		return skprovider.Verdict{}, fmt.Errorf("unknown state %v", verdict.State)
+	}
+
+	return result, nil
+}
+
+// ToProto converts a verdict for sending to skuttle
+func ToProto(verdict skprovider.Verdict) *pb.Verdict {
+	result := &pb.Verdict{
+		Reason:        verdict.Reason,
+		ProviderState: verdict.ProviderState,
+	}
+	if !verdict.ObservedAt.IsZero() {
+		result.ObservedAt = timestamppb.New(verdict.ObservedAt)
+	}
+
+	switch verdict.State {
+	case skprovider.Exists:
+		result.State = pb.State_STATE_EXISTS
+	case skprovider.Gone:
+		result.State = pb.State_STATE_GONE
+	case skprovider.Unknown:
+		result.State = pb.State_STATE_UNKNOWN
+	}
+
+	return result
+}
+
+// Healthy returns an error unless the plugin reports it is serving
+func (provider *Provider) Healthy() error {
+	ctx, cancel := provider.context()
+	defer cancel()
+
+	resp, err := provider.health.Check(ctx, &healthpb.HealthCheckRequest{
+		Service: pb.Provider_ServiceDesc.ServiceName,
+	})
+	if err != nil {
+		return fmt.Errorf("plugin %s health check failed: %v", provider.Address, err)
+	}
+
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("plugin %s is %s", provider.Address, resp.Status)
+	}
+
+	return nil
+}
+
+func (provider *Provider) Close() error {
+	return provider.conn.Close()
+}
+
+func (provider *Provider) context() (context.Context, context.CancelFunc) {
+	if provider.Timeout > 0 {
+		return context.WithTimeout(context.Background(), provider.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
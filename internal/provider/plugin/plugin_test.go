@@ -0,0 +1,249 @@
+package plugin_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/file"
+	"github.com/vixus0/skuttle/v2/internal/provider/plugin"
+	pb "github.com/vixus0/skuttle/v2/internal/provider/plugin/proto"
+
+	"google.golang.org/grpc"
+)
+
+var _ = Describe("Plugin provider", func() {
+	var (
+		dir    string
+		server *grpc.Server
+		client *plugin.Provider
+	)
+
+	serve := func(network, address string, p provider.Provider) net.Addr {
+		listener, err := net.Listen(network, address)
+		Expect(err).To(BeNil())
+
+		server = grpc.NewServer()
+		plugin.NewServer(p).Register(server)
+		go server.Serve(listener)
+
+		return listener.Addr()
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		dir, err = ioutil.TempDir("", "skuttle-plugin")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Stop()
+		os.RemoveAll(dir)
+	})
+
+	// The reference file plugin, over both kinds of transport
+	for _, network := range []string{"unix", "tcp"} {
+		network := network
+
+		Context(fmt.Sprintf("File plugin over %s", network), func() {
+			BeforeEach(func() {
+				var (
+					err     error
+					address string
+				)
+
+				fileProvider := file.NewProviderFromInstances(map[string]file.State{
+					"node1": file.Running,
+					"node2": file.Stopped,
+				})
+
+				switch network {
+				case "unix":
+					socket := filepath.Join(dir, "file.sock")
+					serve("unix", socket, fileProvider)
+					address = "unix://" + socket
+				case "tcp":
+					address = serve("tcp", "127.0.0.1:0", fileProvider).String()
+				}
+
+				client, err = plugin.NewProvider(address, 5*time.Second)
+				Expect(err).To(BeNil())
+			})
+
+			It("should be healthy", func() {
+				Expect(client.Healthy()).To(Succeed())
+			})
+
+			It("should be true only for instances in the node list", func() {
+				Expect(provider.InstanceExists(client, "file://node1")).To(BeTrue())
+				Expect(provider.InstanceExists(client, "file://node2")).To(BeTrue())
+				Expect(provider.InstanceExists(client, "file://node3")).To(BeFalse())
+			})
+
+			It("should pass on the plugin's verdict", func() {
+				verdict, err := client.InstanceVerdict("file://node2")
+
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(provider.Exists))
+				Expect(verdict.ProviderState).To(Equal("stopped"))
+				Expect(verdict.Reason).To(Equal("instance is stopped"))
+				Expect(verdict.ObservedAt).ToNot(BeZero())
+			})
+
+			It("should check several instances at once", func() {
+				verdicts, err := client.InstanceVerdicts([]string{"file://node1", "file://node3"})
+
+				Expect(err).To(BeNil())
+				Expect(verdicts).To(HaveLen(2))
+				Expect(verdicts["file://node1"].State).To(Equal(provider.Exists))
+				Expect(verdicts["file://node3"].State).To(Equal(provider.Gone))
+			})
+		})
+	}
+
+	Context("Provider errors", func() {
+		BeforeEach(func() {
+			var err error
+
+			socket := filepath.Join(dir, "error.sock")
+			serve("unix", socket, &ErrorProvider{})
+
+			client, err = plugin.NewProvider("unix://"+socket, 5*time.Second)
+			Expect(err).To(BeNil())
+		})
+
+		It("should propagate errors", func() {
+			_, err := client.InstanceVerdict("error://node1")
+
+			Expect(err).To(MatchError(ContainSubstring("api unavailable")))
+		})
+
+		It("should propagate errors from batches", func() {
+			_, err := client.InstanceVerdicts([]string{"error://node1", "error://node2"})
+
+			Expect(err).To(MatchError(ContainSubstring("api unavailable")))
+		})
+
+		It("should pass on unknown verdicts", func() {
+			verdict, err := client.InstanceVerdict("unknown://node1")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(provider.Unknown))
+			Expect(verdict.Reason).To(Equal("hypervisor unreachable"))
+		})
+	})
+
+	Context("Plugin without verdicts", func() {
+		BeforeEach(func() {
+			socket := filepath.Join(dir, "empty.sock")
+			listener, err := net.Listen("unix", socket)
+			Expect(err).To(BeNil())
+
+			server = grpc.NewServer()
+			pb.RegisterProviderServer(server, &EmptyServer{})
+			go server.Serve(listener)
+
+			client, err = plugin.NewProvider("unix://"+socket, 5*time.Second)
+			Expect(err).To(BeNil())
+		})
+
+		It("should not know rather than take the instance to be gone", func() {
+			verdict, err := client.InstanceVerdict("file://node1")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(provider.Unknown))
+		})
+
+		It("should return an error for instances missing from batches", func() {
+			_, err := client.InstanceVerdicts([]string{"file://node1"})
+
+			Expect(err).To(MatchError(ContainSubstring("no answer")))
+		})
+	})
+
+	Describe("Converting verdicts", func() {
+		It("should not know without a verdict", func() {
+			verdict, err := plugin.FromProto(nil)
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(provider.Unknown))
+
+			verdict, err = plugin.FromProto(&pb.Verdict{})
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(provider.Unknown))
+		})
+
+		It("should round trip", func() {
+			observed := time.Now().Add(-time.Minute).UTC()
+			verdict, err := plugin.FromProto(plugin.ToProto(provider.Verdict{
+				State:         provider.Unknown,
+				Reason:        "api unreachable",
+				ProviderState: "pending",
+				ObservedAt:    observed,
+			}))
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(provider.Unknown))
+			Expect(verdict.Reason).To(Equal("api unreachable"))
+			Expect(verdict.ProviderState).To(Equal("pending"))
+			Expect(verdict.ObservedAt.Equal(observed)).To(BeTrue())
+		})
+	})
+
+	Context("Plugin is not running", func() {
+		BeforeEach(func() {
+			var err error
+
+			server = grpc.NewServer()
+			client, err = plugin.NewProvider("unix://"+filepath.Join(dir, "nothing.sock"), 500*time.Millisecond)
+			Expect(err).To(BeNil())
+		})
+
+		It("should not be healthy", func() {
+			Expect(client.Healthy()).ToNot(Succeed())
+		})
+
+		It("should return an error", func() {
+			_, err := client.InstanceVerdict("file://node1")
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+type ErrorProvider struct{}
+
+func (p *ErrorProvider) InstanceVerdict(providerID string) (provider.Verdict, error) {
+	switch {
+	case strings.HasPrefix(providerID, "error://"):
+		return provider.Verdict{}, fmt.Errorf("api unavailable")
+	case strings.HasPrefix(providerID, "unknown://"):
+		return provider.Verdict{State: provider.Unknown, Reason: "hypervisor unreachable"}, nil
+	}
+	return provider.VerdictFromExists(true), nil
+}
+
+// EmptyServer answers with empty responses, as a buggy plugin might
+type EmptyServer struct {
+	pb.UnimplementedProviderServer
+}
+
+func (s *EmptyServer) InstanceExists(ctx context.Context, req *pb.InstanceExistsRequest) (*pb.InstanceExistsResponse, error) {
+	return &pb.InstanceExistsResponse{}, nil
+}
+
+func (s *EmptyServer) InstancesExist(ctx context.Context, req *pb.InstancesExistRequest) (*pb.InstancesExistResponse, error) {
+	return &pb.InstancesExistResponse{Exists: map[string]bool{"file://node1": false}}, nil
+}
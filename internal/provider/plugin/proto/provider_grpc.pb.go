@@ -0,0 +1,141 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ProviderClient is the client API for Provider service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ProviderClient interface {
+	// Check whether a single instance exists
+	InstanceExists(ctx context.Context, in *InstanceExistsRequest, opts ...grpc.CallOption) (*InstanceExistsResponse, error)
+	// Check whether several instances exist in one go
+	InstancesExist(ctx context.Context, in *InstancesExistRequest, opts ...grpc.CallOption) (*InstancesExistResponse, error)
+}
+
+type providerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewProviderClient(cc grpc.ClientConnInterface) ProviderClient {
+	return &providerClient{cc}
+}
+
+func (c *providerClient) InstanceExists(ctx context.Context, in *InstanceExistsRequest, opts ...grpc.CallOption) (*InstanceExistsResponse, error) {
+	out := new(InstanceExistsResponse)
+	err := c.cc.Invoke(ctx, "/skuttle.provider.v1.Provider/InstanceExists", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *providerClient) InstancesExist(ctx context.Context, in *InstancesExistRequest, opts ...grpc.CallOption) (*InstancesExistResponse, error) {
+	out := new(InstancesExistResponse)
+	err := c.cc.Invoke(ctx, "/skuttle.provider.v1.Provider/InstancesExist", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ProviderServer is the server API for Provider service.
+// All implementations must embed UnimplementedProviderServer
+// for forward compatibility
+type ProviderServer interface {
+	// Check whether a single instance exists
+	InstanceExists(context.Context, *InstanceExistsRequest) (*InstanceExistsResponse, error)
+	// Check whether several instances exist in one go
+	InstancesExist(context.Context, *InstancesExistRequest) (*InstancesExistResponse, error)
+	mustEmbedUnimplementedProviderServer()
+}
+
+// UnimplementedProviderServer must be embedded to have forward compatible implementations.
+type UnimplementedProviderServer struct {
+}
+
+func (UnimplementedProviderServer) InstanceExists(context.Context, *InstanceExistsRequest) (*InstanceExistsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InstanceExists not implemented")
+}
+func (UnimplementedProviderServer) InstancesExist(context.Context, *InstancesExistRequest) (*InstancesExistResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InstancesExist not implemented")
+}
+func (UnimplementedProviderServer) mustEmbedUnimplementedProviderServer() {}
+
+// UnsafeProviderServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ProviderServer will
+// result in compilation errors.
+type UnsafeProviderServer interface {
+	mustEmbedUnimplementedProviderServer()
+}
+
+func RegisterProviderServer(s grpc.ServiceRegistrar, srv ProviderServer) {
+	s.RegisterService(&Provider_ServiceDesc, srv)
+}
+
+func _Provider_InstanceExists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InstanceExistsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProviderServer).InstanceExists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/skuttle.provider.v1.Provider/InstanceExists",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProviderServer).InstanceExists(ctx, req.(*InstanceExistsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Provider_InstancesExist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InstancesExistRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProviderServer).InstancesExist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/skuttle.provider.v1.Provider/InstancesExist",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProviderServer).InstancesExist(ctx, req.(*InstancesExistRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Provider_ServiceDesc is the grpc.ServiceDesc for Provider service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Provider_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "skuttle.provider.v1.Provider",
+	HandlerType: (*ProviderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "InstanceExists",
+			Handler:    _Provider_InstanceExists_Handler,
+		},
+		{
+			MethodName: "InstancesExist",
+			Handler:    _Provider_InstancesExist_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "provider.proto",
+}
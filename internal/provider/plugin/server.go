@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/vixus0/skuttle/v2/internal/provider"
+	pb "github.com/vixus0/skuttle/v2/internal/provider/plugin/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Server serves a provider to skuttle, for writing plugins in Go
+type Server struct {
+	pb.UnimplementedProviderServer
+
+	Provider provider.Provider
+}
+
+func NewServer(p provider.Provider) *Server {
+	return &Server{
+		Provider: p,
+	}
+}
+
+// Register adds the provider and health services to a gRPC server
+func (s *Server) Register(g *grpc.Server) {
+	pb.RegisterProviderServer(g, s)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(pb.Provider_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(g, healthServer)
+}
+
+func (s *Server) InstanceExists(ctx context.Context, req *pb.InstanceExistsRequest) (*pb.InstanceExistsResponse, error) {
+	if req.ProviderId == "" {
+		return nil, status.Error(codes.InvalidArgument, "provider ID is required")
+	}
+
+	verdict, err := s.Provider.InstanceVerdict(req.ProviderId)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &pb.InstanceExistsResponse{
+		Exists:  verdict.Exists(),
+		Verdict: ToProto(verdict),
+	}, nil
+}
+
+func (s *Server) InstancesExist(ctx context.Context, req *pb.InstancesExistRequest) (*pb.InstancesExistResponse, error) {
+	verdicts, err := provider.InstanceVerdicts(s.Provider, req.ProviderIds)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	resp := &pb.InstancesExistResponse{
+		Exists:   map[string]bool{},
+		Verdicts: map[string]*pb.Verdict{},
+	}
+	for id, verdict := range verdicts {
+		resp.Exists[id] = verdict.Exists()
+		resp.Verdicts[id] = ToProto(verdict)
+	}
+
+	return resp, nil
+}
@@ -1,5 +1,71 @@
 package provider
 
+import (
+	"errors"
+)
+
+// ErrUnsupported is returned by providers which wrap another provider when
+// it can't do what was asked
+var ErrUnsupported = errors.New("not supported by provider")
+
 type Provider interface {
-	InstanceExists(providerID string) (bool, error)
+	InstanceVerdict(providerID string) (Verdict, error)
+}
+
+// BatchProvider is implemented by providers which can give verdicts on
+// several instances at once
+type BatchProvider interface {
+	InstanceVerdicts(providerIDs []string) (map[string]Verdict, error)
+}
+
+// InstanceVerdicts asks for verdicts on several instances, in a single call
+// if the provider supports it and one at a time otherwise
+func InstanceVerdicts(p Provider, providerIDs []string) (map[string]Verdict, error) {
+	if bp, ok := p.(BatchProvider); ok {
+		return bp.InstanceVerdicts(providerIDs)
+	}
+
+	verdicts := map[string]Verdict{}
+	for _, id := range providerIDs {
+		verdict, err := p.InstanceVerdict(id)
+		if err != nil {
+			return nil, err
+		}
+		verdicts[id] = verdict
+	}
+
+	return verdicts, nil
+}
+
+// InstanceExists is false only for instances the provider says are gone
+func InstanceExists(p Provider, providerID string) (bool, error) {
+	verdict, err := p.InstanceVerdict(providerID)
+	if err != nil {
+		return false, err
+	}
+
+	return verdict.Exists(), nil
+}
+
+// InstanceDeleter is implemented by providers which need to clean up more
+// than the node when its instance is gone
+type InstanceDeleter interface {
+	DeleteInstance(providerID string) error
+}
+
+// VolumeChecker is implemented by providers which can tell whether a volume,
+// identified by its CSI volume handle, is still attached to an instance
+type VolumeChecker interface {
+	VolumeAttached(providerID, volumeID string) (bool, error)
+}
+
+// VolumeAttached asks any provider whether a volume is attached to an
+// instance, returning ErrUnsupported if the provider can't tell
+func VolumeAttached(p Provider, providerID, volumeID string) (bool, error) {
+	checker, ok := p.(VolumeChecker)
+	if !ok {
+		return true, ErrUnsupported
+	}
+
+	return checker.VolumeAttached(providerID, volumeID)
 }
@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is a provider's verdict on whether an instance is still there
+type State string
+
+const (
+	// The instance exists, even if it is stopped
+	Exists State = "exists"
+	// The instance is definitely terminated or unknown to the provider
+	Gone State = "gone"
+	// The provider can't tell either way
+	Unknown State = "unknown"
+)
+
+// Verdict is a provider's structured answer about an instance
+type Verdict struct {
+	State State
+	// Why the provider came to its verdict, for logs and events
+	Reason string
+	// The instance's state in the provider's own terms, such as stopped
+	ProviderState string
+	// When the provider was asked
+	ObservedAt time.Time
+}
+
+// NewVerdict describes an instance the provider has just seen in its own
+// providerState
+func NewVerdict(state State, providerState string) Verdict {
+	return Verdict{
+		State:         state,
+		Reason:        fmt.Sprintf("instance is %s", providerState),
+		ProviderState: providerState,
+		ObservedAt:    time.Now(),
+	}
+}
+
+// Exists is false only for instances which are definitely gone
+func (v Verdict) Exists() bool {
+	return v.State != Gone
+}
+
+// ExistsProvider is implemented by providers which can only say whether an
+// instance exists
+type ExistsProvider interface {
+	InstanceExists(providerID string) (bool, error)
+}
+
+// BatchExistsProvider is implemented by ExistsProviders which can check
+// several instances at once
+type BatchExistsProvider interface {
+	InstancesExist(providerIDs []string) (map[string]bool, error)
+}
+
+// VerdictFromExists adapts the answer of a provider which only says
+// whether an instance exists
+func VerdictFromExists(exists bool) Verdict {
+	if exists {
+		return Verdict{
+			State:      Exists,
+			Reason:     "provider reports instance exists",
+			ObservedAt: time.Now(),
+		}
+	}
+
+	return Verdict{
+		State:      Gone,
+		Reason:     "provider reports instance missing",
+		ObservedAt: time.Now(),
+	}
+}
+
+// FromExists adapts a provider which only says whether instances exist,
+// passing on batches, deletions and volume checks if it supports them
+func FromExists(p ExistsProvider) Provider {
+	return &existsAdapter{p}
+}
+
+type existsAdapter struct {
+	ExistsProvider
+}
+
+func (a *existsAdapter) InstanceVerdict(providerID string) (Verdict, error) {
+	exists, err := a.InstanceExists(providerID)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	return VerdictFromExists(exists), nil
+}
+
+func (a *existsAdapter) InstanceVerdicts(providerIDs []string) (map[string]Verdict, error) {
+	verdicts := map[string]Verdict{}
+
+	if bp, ok := a.ExistsProvider.(BatchExistsProvider); ok {
+		exists, err := bp.InstancesExist(providerIDs)
+		if err != nil {
+			return nil, err
+		}
+		for id, ok := range exists {
+			verdicts[id] = VerdictFromExists(ok)
+		}
+		return verdicts, nil
+	}
+
+	for _, id := range providerIDs {
+		verdict, err := a.InstanceVerdict(id)
+		if err != nil {
+			return nil, err
+		}
+		verdicts[id] = verdict
+	}
+
+	return verdicts, nil
+}
+
+func (a *existsAdapter) DeleteInstance(providerID string) error {
+	if deleter, ok := a.ExistsProvider.(InstanceDeleter); ok {
+		return deleter.DeleteInstance(providerID)
+	}
+	return nil
+}
+
+func (a *existsAdapter) VolumeAttached(providerID, volumeID string) (bool, error) {
+	if checker, ok := a.ExistsProvider.(VolumeChecker); ok {
+		return checker.VolumeAttached(providerID, volumeID)
+	}
+	return true, ErrUnsupported
+}
@@ -0,0 +1,157 @@
+package provider_test
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/vixus0/skuttle/v2/internal/provider"
+)
+
+var _ = Describe("Verdicts", func() {
+	var fake *FakeExistsProvider
+
+	BeforeEach(func() {
+		fake = &FakeExistsProvider{
+			Nodes: map[string]bool{
+				"node-exists":  true,
+				"node-missing": false,
+			},
+		}
+	})
+
+	Describe("Adapting providers which only say whether instances exist", func() {
+		It("should turn existing instances into exists verdicts", func() {
+			verdict, err := provider.FromExists(fake).InstanceVerdict("fake://node-exists")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(provider.Exists))
+			Expect(verdict.ObservedAt).ToNot(BeZero())
+		})
+
+		It("should turn missing instances into gone verdicts", func() {
+			verdict, err := provider.FromExists(fake).InstanceVerdict("fake://node-missing")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(provider.Gone))
+		})
+
+		It("should propagate errors", func() {
+			_, err := provider.FromExists(fake).InstanceVerdict("fake://node-error")
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should pass batches on to batch providers", func() {
+			batch := &FakeBatchExistsProvider{FakeExistsProvider: *fake}
+
+			verdicts, err := provider.InstanceVerdicts(provider.FromExists(batch), []string{"fake://node-exists", "fake://node-missing"})
+
+			Expect(err).To(BeNil())
+			Expect(verdicts["fake://node-exists"].State).To(Equal(provider.Exists))
+			Expect(verdicts["fake://node-missing"].State).To(Equal(provider.Gone))
+			Expect(batch.Batches).To(Equal(1))
+			Expect(batch.Calls).To(Equal(0))
+		})
+
+		It("should ask about instances one at a time otherwise", func() {
+			verdicts, err := provider.InstanceVerdicts(provider.FromExists(fake), []string{"fake://node-exists", "fake://node-missing"})
+
+			Expect(err).To(BeNil())
+			Expect(verdicts).To(HaveLen(2))
+			Expect(fake.Calls).To(Equal(2))
+		})
+
+		It("should say volumes can't be checked if the provider doesn't handle them", func() {
+			_, err := provider.VolumeAttached(provider.FromExists(fake), "fake://node-missing", "vol-1")
+
+			Expect(err).To(Equal(provider.ErrUnsupported))
+		})
+	})
+
+	Describe("Asking verdict providers", func() {
+		It("should pass on their verdicts", func() {
+			unknown := &FakeVerdictProvider{
+				Verdict: provider.Verdict{State: provider.Unknown, Reason: "api unreachable"},
+			}
+
+			verdicts, err := provider.InstanceVerdicts(unknown, []string{"fake://node-a", "fake://node-b"})
+
+			Expect(err).To(BeNil())
+			Expect(verdicts).To(HaveLen(2))
+			Expect(verdicts["fake://node-a"].State).To(Equal(provider.Unknown))
+			Expect(verdicts["fake://node-a"].Reason).To(Equal("api unreachable"))
+		})
+	})
+
+	Describe("Whether a verdict means the instance exists", func() {
+		It("should only be false for gone instances", func() {
+			Expect(provider.Verdict{State: provider.Exists}.Exists()).To(BeTrue())
+			Expect(provider.Verdict{State: provider.Unknown}.Exists()).To(BeTrue())
+			Expect(provider.Verdict{State: provider.Gone}.Exists()).To(BeFalse())
+		})
+	})
+
+	Describe("Caching unknown verdicts", func() {
+		It("should not remember them without an error TTL", func() {
+			unknown := &FakeVerdictProvider{
+				Verdict: provider.Verdict{State: provider.Unknown},
+			}
+			cache := provider.NewCachingProvider(unknown, "fake", &provider.CacheConfig{
+				TTL:         time.Minute,
+				NegativeTTL: time.Minute,
+			})
+
+			for i := 0; i < 2; i++ {
+				verdict, err := cache.InstanceVerdict("fake://node-a")
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(provider.Unknown))
+			}
+
+			Expect(unknown.Calls).To(Equal(2))
+		})
+	})
+})
+
+// FakeVerdictProvider gives the same verdict on every instance
+type FakeVerdictProvider struct {
+	Verdict provider.Verdict
+	Calls   int
+}
+
+func (p *FakeVerdictProvider) InstanceVerdict(providerID string) (provider.Verdict, error) {
+	p.Calls++
+	return p.Verdict, nil
+}
+
+// FakeExistsProvider only says whether instances exist
+type FakeExistsProvider struct {
+	Nodes map[string]bool
+	Calls int
+}
+
+func (p *FakeExistsProvider) InstanceExists(providerID string) (bool, error) {
+	p.Calls++
+	id := strings.TrimPrefix(providerID, "fake://")
+	if exists, ok := p.Nodes[id]; ok {
+		return exists, nil
+	}
+	return false, fmt.Errorf("unknown provider ID: %v", providerID)
+}
+
+type FakeBatchExistsProvider struct {
+	FakeExistsProvider
+	Batches int
+}
+
+func (p *FakeBatchExistsProvider) InstancesExist(providerIDs []string) (map[string]bool, error) {
+	p.Batches++
+	exists := map[string]bool{}
+	for _, id := range providerIDs {
+		exists[id] = p.Nodes[strings.TrimPrefix(id, "fake://")]
+	}
+	return exists, nil
+}
@@ -0,0 +1,195 @@
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/session/keepalive"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/vsphere")
+)
+
+// StateMissing is the power state of VMs vSphere doesn't know about
+const StateMissing = "missing"
+
+// VMFinder is the part of the vSphere API used by the provider
+type VMFinder interface {
+	// FindByBIOSUUID returns nil if there is no VM with the given BIOS UUID
+	FindByBIOSUUID(ctx context.Context, uuid string) (*mo.VirtualMachine, error)
+}
+
+type Provider struct {
+	Client VMFinder
+
+	// How long a VM may stay powered off before it is considered gone,
+	// powered off VMs are never considered gone if this is zero
+	PoweredOffTimeout time.Duration
+
+	mu         sync.Mutex
+	poweredOff map[string]time.Time
+}
+
+func NewProvider(ctx context.Context) (*Provider, error) {
+	rawURL, ok := os.LookupEnv("VSPHERE_URL")
+	if !ok {
+		return nil, fmt.Errorf("Need to specify vCenter URL in VSPHERE_URL")
+	}
+
+	u, err := soap.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VSPHERE_URL, %v", err)
+	}
+
+	if username, ok := os.LookupEnv("VSPHERE_USERNAME"); ok {
+		u.User = url.UserPassword(username, os.Getenv("VSPHERE_PASSWORD"))
+	}
+
+	insecure := false
+	if val, ok := os.LookupEnv("VSPHERE_INSECURE"); ok {
+		if insecure, err = strconv.ParseBool(val); err != nil {
+			return nil, fmt.Errorf("invalid VSPHERE_INSECURE, %v", err)
+		}
+	}
+
+	var timeout time.Duration
+	if val, ok := os.LookupEnv("VSPHERE_POWERED_OFF_TIMEOUT"); ok {
+		if timeout, err = time.ParseDuration(val); err != nil {
+			return nil, fmt.Errorf("invalid VSPHERE_POWERED_OFF_TIMEOUT, %v", err)
+		}
+	}
+
+	client, err := vim25.NewClient(ctx, soap.NewClient(u, insecure))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vSphere client, %v", err)
+	}
+
+	// Sessions expire when idle, which they will be while all nodes are Ready
+	client.RoundTripper = keepalive.NewHandlerSOAP(client.RoundTripper, 5*time.Minute, nil)
+
+	log.Info("logging in to %s", u.Host)
+	if err := session.NewManager(client).Login(ctx, u.User); err != nil {
+		return nil, fmt.Errorf("failed to log in to vSphere, %v", err)
+	}
+
+	return &Provider{
+		Client:            NewVMFinder(client),
+		PoweredOffTimeout: timeout,
+	}, nil
+}
+
+// NewVMFinder searches the whole vSphere inventory for VMs
+func NewVMFinder(client *vim25.Client) VMFinder {
+	return &vmFinder{client}
+}
+
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	// should have been checked already, being defensive
+	if !strings.HasPrefix(providerID, "vsphere://") {
+		return skprovider.Verdict{}, fmt.Errorf("providerID %s does not start with vsphere://", providerID)
+	}
+
+	uuid := strings.TrimPrefix(providerID, "vsphere://")
+
+	vm, err := provider.Client.FindByBIOSUUID(context.TODO(), uuid)
+	if err != nil {
+		log.Error("vSphere API error: %v", err)
+		return skprovider.Verdict{}, err
+	}
+
+	if vm == nil {
+		log.Info("no VM found for BIOS UUID %s", uuid)
+		provider.forget(uuid)
+		return skprovider.NewVerdict(skprovider.Gone, StateMissing), nil
+	}
+
+	state := string(vm.Runtime.PowerState)
+
+	if vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOff {
+		provider.forget(uuid)
+		return skprovider.NewVerdict(skprovider.Exists, state), nil
+	}
+
+	since := provider.poweredOffSince(uuid)
+	if provider.PoweredOffTimeout > 0 && since > provider.PoweredOffTimeout {
+		log.Info("VM %s has been powered off for %s (> timeout %s)", uuid, since, provider.PoweredOffTimeout)
+		verdict := skprovider.NewVerdict(skprovider.Gone, state)
+		verdict.Reason = fmt.Sprintf("instance has been %s for %s", state, since.Round(time.Second))
+		return verdict, nil
+	}
+
+	log.Debug("VM %s has been powered off for %s", uuid, since)
+	return skprovider.NewVerdict(skprovider.Exists, state), nil
+}
+
+// There's no record of when a VM was powered off, so count from the first
+// time we saw it that way
+func (provider *Provider) poweredOffSince(uuid string) time.Duration {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.poweredOff == nil {
+		provider.poweredOff = map[string]time.Time{}
+	}
+
+	first, ok := provider.poweredOff[uuid]
+	if !ok {
+		first = time.Now()
+		provider.poweredOff[uuid] = first
+	}
+
+	return time.Since(first)
+}
+
+func (provider *Provider) forget(uuid string) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	delete(provider.poweredOff, uuid)
+}
+
+type vmFinder struct {
+	client *vim25.Client
+}
+
+func (f *vmFinder) FindByBIOSUUID(ctx context.Context, uuid string) (*mo.VirtualMachine, error) {
+	ref, err := object.NewSearchIndex(f.client).FindByUuid(ctx, nil, uuid, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if ref == nil {
+		return nil, nil
+	}
+
+	var vm mo.VirtualMachine
+	err = property.DefaultCollector(f.client).RetrieveOne(ctx, ref.Reference(), []string{"runtime.powerState"}, &vm)
+	if err != nil {
+		// deleted since we searched for it
+		if soap.IsSoapFault(err) {
+			if _, ok := soap.ToSoapFault(err).VimFault().(types.ManagedObjectNotFound); ok {
+				return nil, nil
+			}
+		}
+		return nil, err
+	}
+
+	return &vm, nil
+}
@@ -0,0 +1,13 @@
+package vsphere_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestVsphere(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Vsphere Suite")
+}
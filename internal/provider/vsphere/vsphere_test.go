@@ -0,0 +1,160 @@
+package vsphere_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/vsphere"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+)
+
+const (
+	missingUUID = "00000000-0000-0000-0000-000000000000"
+)
+
+var _ = Describe("vSphere Provider", func() {
+	var (
+		ctx      context.Context
+		model    *simulator.Model
+		server   *simulator.Server
+		client   *govmomi.Client
+		provider *vsphere.Provider
+		vms      []*object.VirtualMachine
+	)
+
+	uuidOf := func(vm *object.VirtualMachine) string {
+		return vm.UUID(ctx)
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		ctx = context.Background()
+
+		// vcsim inventory of a vCenter with running VMs
+		model = simulator.VPX()
+		Expect(model.Create()).To(Succeed())
+
+		server = model.Service.NewServer()
+		client, err = govmomi.NewClient(ctx, server.URL, true)
+		Expect(err).To(BeNil())
+
+		vms, err = find.NewFinder(client.Client).VirtualMachineList(ctx, "*")
+		Expect(err).To(BeNil())
+		Expect(len(vms)).To(BeNumerically(">=", 2))
+
+		provider = &vsphere.Provider{
+			Client: vsphere.NewVMFinder(client.Client),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(client.Logout(ctx)).To(Succeed())
+		server.Close()
+		model.Remove()
+	})
+
+	powerOff := func(vm *object.VirtualMachine) {
+		task, err := vm.PowerOff(ctx)
+		Expect(err).To(BeNil())
+		Expect(task.Wait(ctx)).To(Succeed())
+	}
+
+	Describe("Checking if a vSphere VM exists", func() {
+		It("should be true for powered on VMs", func() {
+			verdict, err := provider.InstanceVerdict(fmt.Sprintf("vsphere://%s", uuidOf(vms[0])))
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+		})
+
+		It("should not be true when the VM was not found", func() {
+			verdict, err := provider.InstanceVerdict(fmt.Sprintf("vsphere://%s", missingUUID))
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Gone))
+		})
+
+		It("should not be true when the VM was deleted", func() {
+			vm := vms[1]
+			uuid := uuidOf(vm)
+
+			powerOff(vm)
+			task, err := vm.Destroy(ctx)
+			Expect(err).To(BeNil())
+			Expect(task.Wait(ctx)).To(Succeed())
+
+			verdict, err := provider.InstanceVerdict(fmt.Sprintf("vsphere://%s", uuid))
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Gone))
+		})
+
+		Context("VM is powered off", func() {
+			var providerID string
+
+			BeforeEach(func() {
+				providerID = fmt.Sprintf("vsphere://%s", uuidOf(vms[0]))
+				powerOff(vms[0])
+			})
+
+			It("should be true without a powered off timeout", func() {
+				verdict, err := provider.InstanceVerdict(providerID)
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Exists))
+
+				time.Sleep(10 * time.Millisecond)
+
+				verdict, err = provider.InstanceVerdict(providerID)
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Exists))
+			})
+
+			It("should not be true once powered off for longer than the timeout", func() {
+				provider.PoweredOffTimeout = 10 * time.Millisecond
+
+				verdict, err := provider.InstanceVerdict(providerID)
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Exists))
+
+				time.Sleep(20 * time.Millisecond)
+
+				verdict, err = provider.InstanceVerdict(providerID)
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Gone))
+			})
+
+			It("should reset the timeout when powered back on", func() {
+				provider.PoweredOffTimeout = 10 * time.Millisecond
+
+				verdict, err := provider.InstanceVerdict(providerID)
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Exists))
+
+				task, err := vms[0].PowerOn(ctx)
+				Expect(err).To(BeNil())
+				Expect(task.Wait(ctx)).To(Succeed())
+
+				verdict, err = provider.InstanceVerdict(providerID)
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Exists))
+
+				powerOff(vms[0])
+				time.Sleep(20 * time.Millisecond)
+
+				verdict, err = provider.InstanceVerdict(providerID)
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Exists))
+			})
+		})
+	})
+})
@@ -0,0 +1,194 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vixus0/skuttle/v2/internal/logging"
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+)
+
+var (
+	log *logging.Logger = logging.NewLogger("provider/webhook")
+)
+
+// Request is the body POSTed to the webhook
+type Request struct {
+	ProviderID string `json:"providerID"`
+}
+
+// Response is the verdict the webhook returns about an instance
+type Response struct {
+	Exists *bool `json:"exists,omitempty"`
+	// One of exists, gone or unknown, which takes precedence over Exists
+	State skprovider.State `json:"state,omitempty"`
+	// Why the webhook came to its verdict
+	Reason string `json:"reason,omitempty"`
+	// The instance's state in the webhook's own terms, such as stopped
+	ProviderState string `json:"providerState,omitempty"`
+}
+
+type Config struct {
+	URL     string
+	Timeout time.Duration
+
+	// Read on every request so that rotated tokens are picked up
+	TokenFile string
+
+	// CA bundle to verify the server with, system roots are used otherwise
+	CAFile string
+
+	// Client certificate to present to the server
+	CertFile string
+	KeyFile  string
+}
+
+// Provider asks an HTTP service whether instances exist.
+//
+// An instance is only missing if a 200, 404 or 410 response has a body saying
+// so, e.g. {"exists": false}, so that a proxy's 404 can't get nodes deleted.
+// A 200 response without a verdict means the instance exists, and anything
+// else is treated as an error. The webhook can also say it can't tell with
+// {"state": "unknown"}.
+type Provider struct {
+	URL       string
+	TokenFile string
+	Client    *http.Client
+}
+
+func NewProvider(cfg *Config) (*Provider, error) {
+	tlsConfig := &tls.Config{}
+
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file, %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate, %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &Provider{
+		URL:       cfg.URL,
+		TokenFile: cfg.TokenFile,
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+		},
+	}, nil
+}
+
+func (provider *Provider) InstanceVerdict(providerID string) (skprovider.Verdict, error) {
+	body, err := json.Marshal(&Request{ProviderID: providerID})
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, provider.URL, bytes.NewReader(body))
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if provider.TokenFile != "" {
+		token, err := ioutil.ReadFile(provider.TokenFile)
+		if err != nil {
+			return skprovider.Verdict{}, fmt.Errorf("failed to read token file, %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := provider.Client.Do(req)
+	if err != nil {
+		return skprovider.Verdict{}, err
+	}
+	defer resp.Body.Close()
+
+	// Include the start of the body in errors, it usually says what went
+	// wrong
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound, http.StatusGone:
+		verdict, ok, err := parseVerdict(msg)
+		if err != nil {
+			return skprovider.Verdict{}, fmt.Errorf("webhook %s returned an invalid verdict for %s: %v", provider.URL, providerID, err)
+		}
+
+		switch {
+		case ok:
+			if verdict.State == skprovider.Gone {
+				log.Info("webhook reports %s missing", providerID)
+			}
+			return verdict, nil
+		case resp.StatusCode == http.StatusOK:
+			return skprovider.VerdictFromExists(true), nil
+		}
+	}
+
+	return skprovider.Verdict{}, fmt.Errorf(
+		"webhook %s returned %s for %s: %s",
+		provider.URL,
+		resp.Status,
+		providerID,
+		strings.TrimSpace(string(msg)),
+	)
+}
+
+// Read the verdict from a response body, returning false if it doesn't have
+// one
+func parseVerdict(body []byte) (skprovider.Verdict, bool, error) {
+	var resp Response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		// Not a verdict, e.g. an error page
+		return skprovider.Verdict{}, false, nil
+	}
+
+	verdict := skprovider.Verdict{
+		State:         resp.State,
+		Reason:        resp.Reason,
+		ProviderState: resp.ProviderState,
+		ObservedAt:    time.Now(),
+	}
+
+	switch resp.State {
+	case skprovider.Exists, skprovider.Gone, skprovider.Unknown:
+	case "":
+		if resp.Exists == nil {
+			return skprovider.Verdict{}, false, nil
+		}
+		verdict.State = skprovider.VerdictFromExists(*resp.Exists).State
+	default:
+		return skprovider.Verdict{}, false, fmt.Errorf("unknown state %q", resp.State)
+	}
+
+	if verdict.Reason == "" {
+		verdict.Reason = fmt.Sprintf("webhook reports instance %s", verdict.State)
+	}
+
+	return verdict, true, nil
+}
@@ -0,0 +1,293 @@
+package webhook_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	skprovider "github.com/vixus0/skuttle/v2/internal/provider"
+	"github.com/vixus0/skuttle/v2/internal/provider/webhook"
+)
+
+const (
+	token = "s3cr3t"
+)
+
+var _ = Describe("Webhook provider", func() {
+	var (
+		dir      string
+		server   *httptest.Server
+		provider *webhook.Provider
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		dir, err = ioutil.TempDir("", "skuttle-webhook")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	Context("Plain HTTP with a bearer token", func() {
+		BeforeEach(func() {
+			var err error
+
+			server = httptest.NewServer(&MockInventory{Token: token})
+
+			tokenFile := filepath.Join(dir, "token")
+			Expect(ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600)).To(Succeed())
+
+			provider, err = webhook.NewProvider(&webhook.Config{
+				URL:       server.URL,
+				TokenFile: tokenFile,
+				Timeout:   time.Second,
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("should be true when the webhook returns 200", func() {
+			verdict, err := provider.InstanceVerdict("inv://node-exists")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+		})
+
+		It("should not be true when the webhook says the instance is missing", func() {
+			for _, id := range []string{"inv://node-missing", "inv://node-gone", "inv://node-terminated"} {
+				verdict, err := provider.InstanceVerdict(id)
+
+				Expect(err).To(BeNil())
+				Expect(verdict.State).To(Equal(skprovider.Gone))
+			}
+		})
+
+		It("should follow the verdict in the body of a 200 response", func() {
+			verdict, err := provider.InstanceVerdict("inv://node-listed-gone")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Gone))
+		})
+
+		It("should return an error for a 404 without a verdict", func() {
+			_, err := provider.InstanceVerdict("inv://wrong-path")
+
+			Expect(err).To(MatchError(ContainSubstring("404")))
+		})
+
+		It("should pass on the webhook's verdict when it can't tell", func() {
+			verdict, err := provider.InstanceVerdict("inv://node-unknown")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Unknown))
+			Expect(verdict.Reason).To(Equal("hypervisor unreachable"))
+		})
+
+		It("should return an error for other responses", func() {
+			_, err := provider.InstanceVerdict("inv://node-error")
+
+			Expect(err).To(MatchError(ContainSubstring("500")))
+			Expect(err).To(MatchError(ContainSubstring("inventory unavailable")))
+		})
+
+		It("should return an error when the token is rejected", func() {
+			Expect(ioutil.WriteFile(provider.TokenFile, []byte("wrong"), 0600)).To(Succeed())
+			_, err := provider.InstanceVerdict("inv://node-exists")
+
+			Expect(err).To(MatchError(ContainSubstring("401")))
+		})
+	})
+
+	Context("Mutual TLS", func() {
+		var (
+			caFile   string
+			certFile string
+			keyFile  string
+		)
+
+		BeforeEach(func() {
+			ca, caKey := newCA()
+			caFile = writePEM(dir, "ca.crt", "CERTIFICATE", ca.Raw)
+
+			serverCert := newCert(ca, caKey, "server")
+			clientCert := newCert(ca, caKey, "skuttle")
+			certFile = writePEM(dir, "client.crt", "CERTIFICATE", clientCert.Certificate[0])
+			keyBytes, err := x509.MarshalPKCS8PrivateKey(clientCert.PrivateKey)
+			Expect(err).To(BeNil())
+			keyFile = writePEM(dir, "client.key", "PRIVATE KEY", keyBytes)
+
+			pool := x509.NewCertPool()
+			pool.AddCert(ca)
+
+			server = httptest.NewUnstartedServer(&MockInventory{})
+			server.TLS = &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    pool,
+			}
+			server.StartTLS()
+		})
+
+		It("should present the client certificate", func() {
+			provider, err := webhook.NewProvider(&webhook.Config{
+				URL:      server.URL,
+				CAFile:   caFile,
+				CertFile: certFile,
+				KeyFile:  keyFile,
+			})
+			Expect(err).To(BeNil())
+
+			verdict, err := provider.InstanceVerdict("inv://node-exists")
+
+			Expect(err).To(BeNil())
+			Expect(verdict.State).To(Equal(skprovider.Exists))
+		})
+
+		It("should fail without a client certificate", func() {
+			provider, err := webhook.NewProvider(&webhook.Config{
+				URL:    server.URL,
+				CAFile: caFile,
+			})
+			Expect(err).To(BeNil())
+
+			_, err = provider.InstanceVerdict("inv://node-exists")
+
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail when the server is not trusted", func() {
+			provider, err := webhook.NewProvider(&webhook.Config{
+				URL:      server.URL,
+				CertFile: certFile,
+				KeyFile:  keyFile,
+			})
+			Expect(err).To(BeNil())
+
+			_, err = provider.InstanceVerdict("inv://node-exists")
+
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+// MockInventory answers based on the posted provider ID
+type MockInventory struct {
+	Token string
+}
+
+func (m *MockInventory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if m.Token != "" && r.Header.Get("Authorization") != "Bearer "+m.Token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req webhook.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch req.ProviderID {
+	case "inv://node-exists":
+		w.WriteHeader(http.StatusOK)
+	case "inv://node-missing":
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"exists": false}`))
+	case "inv://node-gone":
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(`{"state": "gone"}`))
+	case "inv://node-terminated":
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"exists": true, "state": "gone"}`))
+	case "inv://node-listed-gone":
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"exists": false}`))
+	case "inv://node-unknown":
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"state": "unknown", "reason": "hypervisor unreachable"}`))
+	case "inv://wrong-path":
+		// Like a proxy or router which doesn't know the webhook
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 page not found"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("inventory unavailable"))
+	}
+}
+
+func newCA() (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "skuttle test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).To(BeNil())
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).To(BeNil())
+
+	return cert, key
+}
+
+func newCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	Expect(err).To(BeNil())
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func writePEM(dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	Expect(ioutil.WriteFile(path, data, 0600)).To(Succeed())
+	return path
+}